@@ -0,0 +1,118 @@
+package LibraDB
+
+import (
+	"io"
+	"os"
+)
+
+// snapshot is the last committed state of the database, which can be copied after the transaction it was taken in
+// ends, as long as the database is pinned.
+type snapshot struct {
+	metaPage *page
+	maxPage  pgnum
+	pages    map[pgnum]pageKind
+}
+
+// snapshot returns the last committed state of the database. Uncommitted changes are never written to the disk, so
+// it's the same inside a write transaction.
+func (tx *tx) snapshot() (*snapshot, error) {
+	m, err := tx.db.readMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	metaPage, err := tx.db.readPage(metaPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	freelistPage, err := tx.db.readPage(m.freelistPage)
+	if err != nil {
+		return nil, err
+	}
+	freelist := newFreelist()
+	err = freelist.deserialize(freelistPage.data)
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := tx.db.livePages(m)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{metaPage, freelist.maxPage, pages}, nil
+}
+
+// writeSnapshot writes the pages of the snapshot to w, and free pages as zeroes so page numbers stay the same.
+func (d *dal) writeSnapshot(w io.Writer, s *snapshot) (int64, error) {
+	var written int64
+	emptyPage := d.allocateEmptyPage()
+	for pageNum := pgnum(0); pageNum <= s.maxPage; pageNum++ {
+		p := emptyPage
+		if pageNum == metaPageNum {
+			p = s.metaPage
+		} else if _, ok := s.pages[pageNum]; ok {
+			var err error
+			p, err = d.readPage(pageNum)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.Write(p.data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// WriteTo writes a copy of the database to w. The copy is itself a valid database file. Only the meta page, the
+// freelist and the pages reachable from the meta page are copied, free pages are written as zeroes so page numbers
+// stay the same. Uncommitted changes are never written to the disk, so the copy holds the last committed state even
+// inside a write transaction. The number of bytes written is returned.
+func (tx *tx) WriteTo(w io.Writer) (int64, error) {
+	s, err := tx.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	return tx.db.writeSnapshot(w, s)
+}
+
+// Backup writes a copy of the database to a new file at the given path. Only finding the pages to copy runs inside a
+// read transaction. The database is pinned while they are copied, so writers can keep working but the pages they
+// release aren't reused until the copy ends. The file is removed if the backup fails.
+func (db *DB) Backup(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = db.backup(file)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func (db *DB) backup(file *os.File) error {
+	tx := db.ReadTx()
+	s, err := tx.snapshot()
+	if err == nil {
+		db.pin()
+	}
+	tx.Rollback()
+	if err != nil {
+		return err
+	}
+	defer db.unpin()
+
+	_, err = db.writeSnapshot(file, s)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
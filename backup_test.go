@@ -0,0 +1,149 @@
+package LibraDB
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestDB_Backup(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for _, key := range []string{"0", "1", "2", "3", "4", "5", "6", "7", "8"} {
+		val := createItem(key)
+		require.NoError(t, collection.Put(val, val))
+	}
+	require.NoError(t, tx.Commit())
+
+	path := getTempFileName()
+	defer os.Remove(path)
+	require.NoError(t, db.Backup(path))
+
+	backup, err := Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer backup.Close()
+
+	tx = db.ReadTx()
+	expected, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	backupTx := backup.ReadTx()
+	actual, err := backupTx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	areCollectionsEqual(t, expected, actual)
+	areTreesEqual(t, expected, actual)
+
+	require.NoError(t, backupTx.Commit())
+	require.NoError(t, tx.Commit())
+}
+
+func TestDB_BackupExistingFile(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	path := getTempFileName()
+	require.NoError(t, os.WriteFile(path, []byte{}, 0666))
+	defer os.Remove(path)
+
+	assert.Error(t, db.Backup(path))
+}
+
+func TestDB_BackupRemovesFileOnFailure(t *testing.T) {
+	storage := newFaultStorage()
+	db, err := OpenStorage(storage, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer db.Close()
+	storage.failed = true
+
+	path := getTempFileName()
+	defer os.Remove(path)
+	assert.ErrorIs(t, db.Backup(path), errInjectedFault)
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDB_BackupDoesntBlockWriters(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for _, key := range []string{"0", "1", "2", "3", "4", "5", "6", "7", "8"} {
+		val := createItem(key)
+		require.NoError(t, collection.Put(val, val))
+	}
+	require.NoError(t, tx.Commit())
+	expected := bytes.Buffer{}
+	tx = db.ReadTx()
+	_, err = tx.WriteTo(&expected)
+	require.NoError(t, err)
+	tx.Rollback()
+
+	// The same steps as Backup, with writers committing before the pages are copied
+	tx = db.ReadTx()
+	s, err := tx.snapshot()
+	require.NoError(t, err)
+	db.pin()
+	tx.Rollback()
+
+	for i := 0; i < 3; i++ {
+		tx = db.WriteTx()
+		collection, err = tx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+		for _, key := range []string{"0", "2", "4", "6", "8"} {
+			require.NoError(t, collection.Put(createItem(key), []byte(key)))
+		}
+		require.NoError(t, collection.Remove(createItem("1")))
+		require.NoError(t, tx.Commit())
+	}
+	_, err = db.Compact()
+	assert.ErrorIs(t, err, ErrBackupInProgress)
+
+	actual := bytes.Buffer{}
+	_, err = db.writeSnapshot(&actual, s)
+	require.NoError(t, err)
+	db.unpin()
+	assert.Equal(t, expected.Bytes(), actual.Bytes())
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+}
+
+func TestTx_WriteToSkipsUncommittedChanges(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	committed := bytes.Buffer{}
+	_, err = tx.WriteTo(&committed)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	val := createItem("0")
+	require.NoError(t, collection.Put(val, val))
+
+	uncommitted := bytes.Buffer{}
+	n, err := tx.WriteTo(&uncommitted)
+	require.NoError(t, err)
+	tx.Rollback()
+
+	assert.Equal(t, int64(uncommitted.Len()), n)
+	assert.Equal(t, committed.Bytes(), uncommitted.Bytes())
+}
@@ -0,0 +1,306 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type ViolationKind int
+
+const (
+	// KeyOrderViolation means a key is out of order inside its node or outside the range its parent allows.
+	KeyOrderViolation ViolationKind = iota
+	// ChildPointerViolation means a page number points outside the file or to a page that can't hold a node.
+	ChildPointerViolation
+	// FillViolation means a node is bigger or smaller than the fill percents allow.
+	FillViolation
+	// DepthViolation means the leaves of a collection aren't all at the same depth.
+	DepthViolation
+	// DuplicatePageViolation means a page is referenced more than once.
+	DuplicatePageViolation
+	// LeakedPageViolation means a page is neither reachable nor in the freelist.
+	LeakedPageViolation
+	// FreelistViolation means the freelist is corrupted, or holds a page that is in use.
+	FreelistViolation
+	// CollectionViolation means a value in the root collection isn't a valid collection.
+	CollectionViolation
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case KeyOrderViolation:
+		return "key order"
+	case ChildPointerViolation:
+		return "child pointer"
+	case FillViolation:
+		return "fill"
+	case DepthViolation:
+		return "depth"
+	case DuplicatePageViolation:
+		return "duplicate page"
+	case LeakedPageViolation:
+		return "leaked page"
+	case FreelistViolation:
+		return "freelist"
+	case CollectionViolation:
+		return "collection"
+	default:
+		return "unknown"
+	}
+}
+
+// Violation is a single problem found by Check.
+type Violation struct {
+	Kind ViolationKind
+	// Collection is the name of the collection the problem was found in. It's nil for the root collection and for
+	// problems that don't belong to any collection.
+	Collection []byte
+	Page       uint64
+	Message    string
+}
+
+func (v Violation) String() string {
+	if v.Collection == nil {
+		return fmt.Sprintf("page %d: %s: %s", v.Page, v.Kind, v.Message)
+	}
+	return fmt.Sprintf("collection %q page %d: %s: %s", v.Collection, v.Page, v.Kind, v.Message)
+}
+
+// CheckReport is the result of Check. The database is consistent if there are no violations.
+type CheckReport struct {
+	Violations []Violation
+
+	Collections    int
+	ReachablePages int
+	FreePages      int
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// checker holds the state of a single Check run.
+type checker struct {
+	tx     *tx
+	report *CheckReport
+
+	// referenced holds every page reachable from the meta page.
+	referenced map[pgnum]bool
+	// free holds every page in the freelist.
+	free map[pgnum]bool
+	// freelistPages holds the pages the freelist is written to.
+	freelistPages map[pgnum]bool
+
+	// collection is the name of the collection currently being checked and leafDepth the depth of its first leaf.
+	collection []byte
+	leafDepth  int
+	// compare orders the keys of the collection. Key order isn't checked if it's nil, when the comparator of the
+	// collection isn't registered.
+	compare Comparator
+}
+
+// Check walks the root collection and every collection tree and verifies the b-tree invariants: keys are ordered
+// across nodes, child pointers are valid, nodes are within the fill bounds, all leaves of a tree are at the same depth,
+// no page is referenced twice and every page is either reachable or in the freelist. All the violations found are
+// returned in the report. An error is returned only if the check itself couldn't be completed.
+func (db *DB) Check() (*CheckReport, error) {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	c := &checker{
+		tx:            tx,
+		report:        &CheckReport{},
+		compare:       bytes.Compare,
+		referenced:    map[pgnum]bool{},
+		free:          map[pgnum]bool{},
+		freelistPages: map[pgnum]bool{},
+	}
+
+	c.checkFreelist()
+
+	collections := make([]*Collection, 0)
+	c.leafDepth = -1
+	err := c.checkNode(tx.root, 0, nil, nil, 0, func(node *Node) {
+		for _, item := range node.items {
+			if !isCollectionKey(item.key) {
+				continue
+			}
+			if !isValidCollectionValue(item.value) {
+				c.addViolation(CollectionViolation, node.pageNum, "collection %q has an invalid value of %d bytes", item.key, len(item.value))
+				continue
+			}
+			collection := newEmptyCollection()
+			collection.deserialize(item)
+			collections = append(collections, collection)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, collection := range collections {
+		c.collection = collection.name
+		c.leafDepth = -1
+		collection.tx = tx
+		c.compare = nil
+		if collection.checkComparator() == nil {
+			c.compare = collection.compare()
+		} else {
+			c.addViolation(CollectionViolation, collection.root, "comparator %q isn't registered, so key order can't be checked", collection.comparator)
+		}
+		err = c.checkNode(collection.root, 0, nil, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.collection = nil
+	c.compare = bytes.Compare
+
+	for pageNum := pgnum(1); pageNum <= tx.db.maxPage; pageNum++ {
+		if c.freelistPages[pageNum] {
+			continue
+		}
+		if c.referenced[pageNum] && c.free[pageNum] {
+			c.addViolation(FreelistViolation, pageNum, "page is reachable but is also in the freelist")
+		} else if !c.referenced[pageNum] && !c.free[pageNum] {
+			c.addViolation(LeakedPageViolation, pageNum, "page is neither reachable nor in the freelist")
+		}
+	}
+
+	c.report.Collections = len(collections)
+	c.report.ReachablePages = len(c.referenced)
+	c.report.FreePages = len(c.free)
+	return c.report, nil
+}
+
+func (c *checker) addViolation(kind ViolationKind, pageNum pgnum, format string, args ...interface{}) {
+	c.report.Violations = append(c.report.Violations, Violation{
+		Kind:       kind,
+		Collection: c.collection,
+		Page:       uint64(pageNum),
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) checkFreelist() {
+	db := c.tx.db
+	for _, pageNum := range db.freelistPages() {
+		c.referenced[pageNum] = true
+		c.freelistPages[pageNum] = true
+	}
+
+	for _, pageNum := range db.releasedPages {
+		switch {
+		case pageNum == metaPageNum || pageNum > db.maxPage:
+			c.addViolation(FreelistViolation, pageNum, "released page is out of range [1, %d]", db.maxPage)
+		case c.freelistPages[pageNum]:
+			c.addViolation(FreelistViolation, pageNum, "the freelist page is released")
+		case c.free[pageNum]:
+			c.addViolation(FreelistViolation, pageNum, "page is released more than once")
+		}
+		c.free[pageNum] = true
+	}
+}
+
+// isValidPointer checks a page number read from a node or from a collection before it's followed.
+func (c *checker) isValidPointer(pageNum, parent pgnum) bool {
+	db := c.tx.db
+	switch {
+	case pageNum == metaPageNum || pageNum > db.maxPage:
+		c.addViolation(ChildPointerViolation, parent, "page %d is out of range [1, %d]", pageNum, db.maxPage)
+		return false
+	case c.freelistPages[pageNum]:
+		c.addViolation(ChildPointerViolation, parent, "page %d is a freelist page", pageNum)
+		return false
+	case c.referenced[pageNum]:
+		c.addViolation(DuplicatePageViolation, parent, "page %d is referenced more than once", pageNum)
+		return false
+	}
+	return true
+}
+
+// checkNode checks the subtree rooted at pageNum. Every key in it must be bigger than lower and smaller than upper
+// (nil means unbounded). visit is called for every node of the subtree, so the caller can inspect the items.
+func (c *checker) checkNode(pageNum, parent pgnum, lower, upper []byte, depth int, visit func(node *Node)) error {
+	if !c.isValidPointer(pageNum, parent) {
+		return nil
+	}
+	c.referenced[pageNum] = true
+
+	// The rest of the tree is still checked when a node can't be read
+	node, err := c.tx.getNode(pageNum)
+	if err != nil {
+		c.addViolation(ChildPointerViolation, parent, "page %d can't be read as a node: %s", pageNum, err)
+		return nil
+	}
+
+	c.checkFill(node, depth == 0)
+
+	for i, item := range node.items {
+		if c.compare == nil {
+			break
+		}
+		if i > 0 && c.compare(node.items[i-1].key, item.key) >= 0 {
+			c.addViolation(KeyOrderViolation, pageNum, "key %d is not bigger than the key before it", i)
+		}
+		if lower != nil && c.compare(item.key, lower) <= 0 {
+			c.addViolation(KeyOrderViolation, pageNum, "key %d is not bigger than the separator in the parent", i)
+		}
+		if upper != nil && c.compare(item.key, upper) >= 0 {
+			c.addViolation(KeyOrderViolation, pageNum, "key %d is not smaller than the separator in the parent", i)
+		}
+	}
+
+	if visit != nil {
+		visit(node)
+	}
+
+	if node.isLeaf() {
+		if c.leafDepth == -1 {
+			c.leafDepth = depth
+		} else if c.leafDepth != depth {
+			c.addViolation(DepthViolation, pageNum, "leaf is at depth %d while other leaves are at depth %d", depth, c.leafDepth)
+		}
+		return nil
+	}
+
+	for i, childNode := range node.childNodes {
+		childLower, childUpper := lower, upper
+		if i > 0 {
+			childLower = node.items[i-1].key
+		}
+		if i < len(node.items) {
+			childUpper = node.items[i].key
+		}
+		err = c.checkNode(childNode, pageNum, childLower, childUpper, depth+1, visit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFill checks the node is within the fill bounds. Splitting a node that just crossed the max threshold leaves the
+// left half just over the min threshold, and the rest in the right half. When the min threshold is more than half the
+// max threshold, the right half may be under it, so non root nodes are only required to be bigger than the max
+// threshold less the min threshold and the two items the halves may be off by.
+func (c *checker) checkFill(node *Node, isRoot bool) {
+	db := c.tx.db
+	size := float32(node.nodeSize())
+	if size > db.maxThreshold() {
+		c.addViolation(FillViolation, node.pageNum, "node size %d is over the max threshold %.0f", node.nodeSize(), db.maxThreshold())
+	}
+
+	if isRoot {
+		return
+	}
+	minThreshold := db.minThreshold()
+	if 2*minThreshold > db.maxThreshold() {
+		minThreshold = db.maxThreshold() - minThreshold - 2*maxElementSize
+	}
+	if len(node.items) == 0 {
+		c.addViolation(FillViolation, node.pageNum, "node is empty")
+	} else if size < minThreshold {
+		c.addViolation(FillViolation, node.pageNum, "node size %d is under the min threshold %.0f", node.nodeSize(), minThreshold)
+	}
+}
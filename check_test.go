@@ -0,0 +1,178 @@
+package LibraDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func requireViolation(t *testing.T, report *CheckReport, kind ViolationKind) {
+	for _, violation := range report.Violations {
+		if violation.Kind == kind {
+			return
+		}
+	}
+	require.Failf(t, "violation not found", "expected a %s violation in %v", kind, report.Violations)
+}
+
+func TestDB_CheckConsistentDatabase(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		val := append(createItem("0")[:testValSize-2], strconv.Itoa(10+i)...)
+		require.NoError(t, collection.Put(val, val))
+	}
+	for i := 0; i < 50; i += 3 {
+		val := append(createItem("0")[:testValSize-2], strconv.Itoa(10+i)...)
+		require.NoError(t, collection.Remove(val))
+	}
+	_, err = tx.CreateCollection([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+	assert.Equal(t, 2, report.Collections)
+	assert.Equal(t, int(db.maxPage), report.ReachablePages+report.FreePages)
+}
+
+func TestDB_CheckKeyOrder(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	db := collection.tx.db
+	root, err := db.getNode(collection.root)
+	require.NoError(t, err)
+	root.items[0], root.items[1] = root.items[1], root.items[0]
+	_, err = db.writeNode(root)
+	require.NoError(t, err)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	requireViolation(t, report, KeyOrderViolation)
+	assert.Equal(t, testCollectionName, report.Violations[0].Collection)
+	assert.Equal(t, uint64(collection.root), report.Violations[0].Page)
+}
+
+func TestDB_CheckDuplicatePage(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	db := collection.tx.db
+	root, err := db.getNode(collection.root)
+	require.NoError(t, err)
+	root.childNodes[2] = root.childNodes[1]
+	_, err = db.writeNode(root)
+	require.NoError(t, err)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	requireViolation(t, report, DuplicatePageViolation)
+	requireViolation(t, report, LeakedPageViolation)
+}
+
+func TestDB_CheckChildPointerAndDepth(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	db := collection.tx.db
+	root, err := db.getNode(collection.root)
+	require.NoError(t, err)
+	child, err := db.getNode(root.childNodes[1])
+	require.NoError(t, err)
+	leaf0, err := db.writeNode(NewNodeForSerialization(createItems("3"), []pgnum{}))
+	require.NoError(t, err)
+	leaf1, err := db.writeNode(NewNodeForSerialization(createItems("4"), []pgnum{}))
+	require.NoError(t, err)
+	child.items = []*Item{newItem(append(createItem("3"), '3'), nil)}
+	child.childNodes = []pgnum{leaf0.pageNum, leaf1.pageNum}
+	_, err = db.writeNode(child)
+	require.NoError(t, err)
+	root.childNodes[0] = db.maxPage + 1
+	_, err = db.writeNode(root)
+	require.NoError(t, err)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	requireViolation(t, report, ChildPointerViolation)
+	requireViolation(t, report, DepthViolation)
+}
+
+func TestDB_CheckFreelist(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	db := collection.tx.db
+	db.releasePage(collection.root)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	requireViolation(t, report, FreelistViolation)
+}
+
+func TestDB_CheckFillDefaultOptions(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), DefaultOptions)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, collection.Put([]byte(strconv.Itoa(1000+i)), make([]byte, 250)))
+	}
+	// Replacing values with smaller ones rebalances the nodes they shrink
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, collection.Put([]byte(strconv.Itoa(1000+i)), nil))
+	}
+	require.NoError(t, tx.Commit())
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+
+	root, err := db.getNode(collection.root)
+	require.NoError(t, err)
+	child, err := db.getNode(root.childNodes[0])
+	require.NoError(t, err)
+	child.items = child.items[:1]
+	_, err = db.writeNode(child)
+	require.NoError(t, err)
+
+	report, err = db.Check()
+	require.NoError(t, err)
+	requireViolation(t, report, FillViolation)
+}
+
+func TestDB_CheckUnreadableNode(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	db := collection.tx.db
+	root, err := db.getNode(collection.root)
+	require.NoError(t, err)
+	for _, pageNum := range root.childNodes[:2] {
+		p := db.allocateEmptyPage()
+		p.num = pageNum
+		for i := range p.data {
+			p.data[i] = 0xff
+		}
+		require.NoError(t, db.writePage(p))
+	}
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	unreadable := 0
+	for _, violation := range report.Violations {
+		if violation.Kind == ChildPointerViolation {
+			unreadable++
+		}
+	}
+	assert.Equal(t, 2, unreadable, "%v", report.Violations)
+}
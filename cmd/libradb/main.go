@@ -0,0 +1,293 @@
+// Command libradb inspects and maintains LibraDB database files. Run it without arguments for the list of commands.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/amit-davidson/LibraDB"
+)
+
+const usage = `usage: libradb <command> <db path> [arguments]
+
+commands:
+  info                           print the page size, meta page and freelist stats
+  collections                    list the collections
+  get <collection> <key>         print the value of a key
+  put <collection> <key> <value> set the value of a key, creating the collection if needed
+  delete <collection> <key>      remove a key
+  scan <collection> [prefix]     print the key/value pairs, optionally only those starting with prefix
+  dump-page <page>               print a hex dump of a page and its decoded content
+  check                          verify the integrity of the database
+  compact                        shrink the database file
+  backup <path>                  write a copy of the database to a new file
+  export [collection]            write the key/value pairs as base64 encoded JSON Lines to the standard output
+  import <path>                  load key/value pairs from a JSON Lines file written by export
+`
+
+var (
+	errUsage              = errors.New("invalid arguments")
+	errCollectionNotFound = errors.New("collection not found")
+	errKeyNotFound        = errors.New("key not found")
+	errCheckFailed        = errors.New("check failed")
+)
+
+type command struct {
+	// args is the number of arguments after the db path. If optional is set, the last one may be omitted.
+	args     int
+	optional bool
+	// create allows the command to create the database file if it doesn't exist.
+	create bool
+	run    func(db *LibraDB.DB, args []string, w io.Writer) error
+}
+
+var commands = map[string]command{
+	"info":        {args: 0, run: info},
+	"collections": {args: 0, run: collections},
+	"get":         {args: 2, run: get},
+	"put":         {args: 3, create: true, run: put},
+	"delete":      {args: 2, run: del},
+	"scan":        {args: 2, optional: true, run: scan},
+	"dump-page":   {args: 1, run: dumpPage},
+	"check":       {args: 0, run: check},
+	"compact":     {args: 0, run: compact},
+	"backup":      {args: 1, run: backup},
+	"export":      {args: 1, optional: true, run: export},
+	"import":      {args: 1, create: true, run: importFile},
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "libradb:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	path, cmdArgs := args[1], args[2:]
+	if len(cmdArgs) != cmd.args && !(cmd.optional && len(cmdArgs) == cmd.args-1) {
+		return fmt.Errorf("%w: %s takes %d arguments", errUsage, args[0], cmd.args)
+	}
+
+	if !cmd.create {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	db, err := LibraDB.Open(path, LibraDB.DefaultOptions)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.run(db, cmdArgs, w)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func info(db *LibraDB.DB, _ []string, w io.Writer) error {
+	stats := db.Stats()
+	_, err := fmt.Fprintf(w, "page size:     %d\npages:         %d\nroot page:     %d\nfreelist page: %d\nfree pages:    %d\n",
+		stats.PageSize, stats.PageCount, stats.RootPage, stats.FreelistPage, stats.FreePages)
+	return err
+}
+
+func collections(db *LibraDB.DB, _ []string, w io.Writer) error {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	collections, err := tx.Collections()
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		_, err = fmt.Fprintf(w, "%s\n", collection.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func get(db *LibraDB.DB, args []string, w io.Writer) error {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	collection, err := getCollection(tx, args[0])
+	if err != nil {
+		return err
+	}
+
+	item, err := collection.Find([]byte(args[1]))
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return errKeyNotFound
+	}
+	_, err = fmt.Fprintf(w, "%s\n", item.Value())
+	return err
+}
+
+func put(db *LibraDB.DB, args []string, _ io.Writer) error {
+	tx := db.WriteTx()
+
+	collection, err := tx.GetCollection([]byte(args[0]))
+	if err == nil && collection == nil {
+		collection, err = tx.CreateCollection([]byte(args[0]))
+	}
+	if err == nil {
+		err = collection.Put([]byte(args[1]), []byte(args[2]))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func del(db *LibraDB.DB, args []string, _ io.Writer) error {
+	tx := db.WriteTx()
+
+	collection, err := getCollection(tx, args[0])
+	if err == nil {
+		err = collection.Remove([]byte(args[1]))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func scan(db *LibraDB.DB, args []string, w io.Writer) error {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	collection, err := getCollection(tx, args[0])
+	if err != nil {
+		return err
+	}
+
+	var prefix []byte
+	if len(args) > 1 {
+		prefix = []byte(args[1])
+	}
+
+	cursor := collection.Cursor()
+	key, value, err := cursor.Seek(prefix)
+	for ; key != nil && err == nil && bytes.HasPrefix(key, prefix); key, value, err = cursor.Next() {
+		_, err = fmt.Fprintf(w, "%s\t%s\n", key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func dumpPage(db *LibraDB.DB, args []string, w io.Writer) error {
+	pageNum, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid page number %q", errUsage, args[0])
+	}
+	return db.DumpPage(w, pageNum)
+}
+
+func check(db *LibraDB.DB, _ []string, w io.Writer) error {
+	report, err := db.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, violation := range report.Violations {
+		_, err = fmt.Fprintln(w, violation)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "%d collections, %d reachable pages, %d free pages, %d violations\n",
+		report.Collections, report.ReachablePages, report.FreePages, len(report.Violations))
+	if err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func compact(db *LibraDB.DB, _ []string, w io.Writer) error {
+	reclaimed, err := db.Compact()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "reclaimed %d bytes\n", reclaimed)
+	return err
+}
+
+func backup(db *LibraDB.DB, args []string, _ io.Writer) error {
+	return db.Backup(args[0])
+}
+
+func export(db *LibraDB.DB, args []string, w io.Writer) error {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	options := &LibraDB.ExportOptions{}
+	if len(args) > 0 {
+		options.Collections = [][]byte{[]byte(args[0])}
+	}
+	return tx.Export(w, options)
+}
+
+func importFile(db *LibraDB.DB, args []string, w io.Writer) error {
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := db.Import(file, &LibraDB.ImportOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "imported %d records\n", n)
+	return err
+}
+
+type collectionGetter interface {
+	GetCollection(name []byte) (*LibraDB.Collection, error)
+}
+
+// getCollection is used by the commands that expect the collection to exist.
+func getCollection(tx collectionGetter, name string) (*LibraDB.Collection, error) {
+	collection, err := tx.GetCollection([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+	if collection == nil {
+		return nil, fmt.Errorf("%w: %s", errCollectionNotFound, name)
+	}
+	return collection, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/amit-davidson/LibraDB"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func getTempFileName() string {
+	return fmt.Sprintf("%s%c%s", os.TempDir(), os.PathSeparator, uuid.New())
+}
+
+func runCommand(t *testing.T, args ...string) (string, error) {
+	out := bytes.Buffer{}
+	err := run(args, &out)
+	return out.String(), err
+}
+
+func TestRun(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	_, err := runCommand(t, "info", path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = runCommand(t, "put", path, "users", "user1", "alice")
+	require.NoError(t, err)
+	_, err = runCommand(t, "put", path, "users", "user2", "bob")
+	require.NoError(t, err)
+	_, err = runCommand(t, "put", path, "users", "admin1", "carol")
+	require.NoError(t, err)
+
+	out, err := runCommand(t, "get", path, "users", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice\n", out)
+
+	out, err = runCommand(t, "collections", path)
+	require.NoError(t, err)
+	assert.Equal(t, "users\n", out)
+
+	out, err = runCommand(t, "scan", path, "users", "user")
+	require.NoError(t, err)
+	assert.Equal(t, "user1\talice\nuser2\tbob\n", out)
+
+	out, err = runCommand(t, "scan", path, "users")
+	require.NoError(t, err)
+	assert.Equal(t, "admin1\tcarol\nuser1\talice\nuser2\tbob\n", out)
+
+	_, err = runCommand(t, "delete", path, "users", "user1")
+	require.NoError(t, err)
+	_, err = runCommand(t, "get", path, "users", "user1")
+	require.ErrorIs(t, err, errKeyNotFound)
+
+	_, err = runCommand(t, "get", path, "groups", "user1")
+	require.ErrorIs(t, err, errCollectionNotFound)
+
+	out, err = runCommand(t, "info", path)
+	require.NoError(t, err)
+	assert.Regexp(t, `root page: +\d+`, out)
+
+	out, err = runCommand(t, "dump-page", path, "0")
+	require.NoError(t, err)
+	assert.Regexp(t, `meta: txid=\d+ root=\d+ freelist=\d+`, out)
+
+	out, err = runCommand(t, "check", path)
+	require.NoError(t, err)
+	assert.Contains(t, out, "0 violations")
+
+	out, err = runCommand(t, "compact", path)
+	require.NoError(t, err)
+	assert.Contains(t, out, "reclaimed")
+
+	backupPath := getTempFileName()
+	defer os.Remove(backupPath)
+	_, err = runCommand(t, "backup", path, backupPath)
+	require.NoError(t, err)
+	out, err = runCommand(t, "get", backupPath, "users", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, "bob\n", out)
+
+	exported, err := runCommand(t, "export", path, "users")
+	require.NoError(t, err)
+	exportPath := getTempFileName()
+	defer os.Remove(exportPath)
+	require.NoError(t, os.WriteFile(exportPath, []byte(exported), 0666))
+
+	importedPath := getTempFileName()
+	defer os.Remove(importedPath)
+	out, err = runCommand(t, "import", importedPath, exportPath)
+	require.NoError(t, err)
+	// The metadata of the collection and its two pairs
+	assert.Equal(t, "imported 3 records\n", out)
+	out, err = runCommand(t, "scan", importedPath, "users")
+	require.NoError(t, err)
+	assert.Equal(t, "admin1\tcarol\nuser2\tbob\n", out)
+}
+
+func TestRunUsage(t *testing.T) {
+	_, err := runCommand(t)
+	assert.ErrorIs(t, err, errUsage)
+
+	_, err = runCommand(t, "unknown", "path")
+	assert.ErrorIs(t, err, errUsage)
+
+	_, err = runCommand(t, "get", "path", "collection")
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestRunMergedCollection(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	options := *LibraDB.DefaultOptions
+	options.MergeOperators = map[string]LibraDB.MergeOperator{"words": LibraDB.AppendMerge}
+	db, err := LibraDB.Open(path, &options)
+	require.NoError(t, err)
+	tx := db.WriteTx()
+	words, err := tx.CreateCollection([]byte("words"))
+	require.NoError(t, err)
+	require.NoError(t, words.Merge([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// The command opens the database without the merge operator
+	out, err := runCommand(t, "get", path, "words", "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value\n", out)
+
+	out, err = runCommand(t, "collections", path)
+	require.NoError(t, err)
+	assert.Equal(t, "words\n", out)
+
+	out, err = runCommand(t, "export", path)
+	require.NoError(t, err)
+	assert.Contains(t, out, `"mergeOperator":"append"`)
+}
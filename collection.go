@@ -3,6 +3,7 @@ package LibraDB
 import (
 	"bytes"
 	"encoding/binary"
+	"time"
 )
 
 type Collection struct {
@@ -10,6 +11,14 @@ type Collection struct {
 	root pgnum
 	counter uint64
 
+	// comparator is the name of the comparator ordering the keys, empty for bytes.Compare.
+	comparator string
+	// mergeOperator is the name of the merge operator the values were merged with, empty if Merge was never called.
+	mergeOperator string
+
+	// isRoot marks the root collection, whose root page is stored in the meta page rather than in another collection.
+	isRoot bool
+
 	// associated transaction
 	tx *tx
 
@@ -26,26 +35,67 @@ func newEmptyCollection() *Collection {
 	return &Collection{}
 }
 
+func (c *Collection) Name() []byte {
+	return c.name
+}
+
+// ID returns a unique id from the sequence of the collection, starting from 0. It returns 0 as well if the sequence
+// can't be incremented, for example in a read transaction.
+//
+// Deprecated: Use NextSequence, which reports errors.
 func (c *Collection) ID() uint64 {
-	if !c.tx.write {
+	id, err := c.NextSequence()
+	if err != nil {
 		return 0
 	}
-
-	id := c.counter
-	c.counter += 1
-	return id
+	return id - 1
 }
 
+// serialize writes the root page and the sequence of the collection. The name of the comparator follows, prefixed by
+// its length, only if the collection has a comparator or a merge operator. The name of the merge operator follows it the
+// same way, only if the collection has one.
 func (c *Collection) serialize() *Item {
-	b := make([]byte, collectionSize)
+	size := collectionSize
+	if c.comparator != "" || c.mergeOperator != "" {
+		size += 1 + len(c.comparator)
+	}
+	if c.mergeOperator != "" {
+		size += 1 + len(c.mergeOperator)
+	}
+	b := make([]byte, size)
 	leftPos := 0
 	binary.LittleEndian.PutUint64(b[leftPos:], uint64(c.root))
 	leftPos += pageNumSize
 	binary.LittleEndian.PutUint64(b[leftPos:], c.counter)
 	leftPos += counterSize
+	if c.comparator != "" || c.mergeOperator != "" {
+		b[leftPos] = byte(len(c.comparator))
+		leftPos += 1
+		leftPos += copy(b[leftPos:], c.comparator)
+	}
+	if c.mergeOperator != "" {
+		b[leftPos] = byte(len(c.mergeOperator))
+		leftPos += 1
+		copy(b[leftPos:], c.mergeOperator)
+	}
 	return newItem(c.name, b)
 }
 
+// isValidCollectionValue reports whether the value can be deserialized as a collection.
+func isValidCollectionValue(value []byte) bool {
+	if len(value) == collectionSize {
+		return true
+	}
+	if len(value) < collectionSize+1 {
+		return false
+	}
+	comparatorEnd := collectionSize + 1 + int(value[collectionSize])
+	if len(value) == comparatorEnd {
+		return true
+	}
+	return len(value) > comparatorEnd && len(value) == comparatorEnd+1+int(value[comparatorEnd])
+}
+
 func (c *Collection) deserialize(item *Item) {
 	c.name = item.key
 
@@ -56,6 +106,18 @@ func (c *Collection) deserialize(item *Item) {
 
 		c.counter = binary.LittleEndian.Uint64(item.value[leftPos:])
 		leftPos += counterSize
+
+		if isValidCollectionValue(item.value) && len(item.value) > collectionSize {
+			size := int(item.value[leftPos])
+			leftPos += 1
+			c.comparator = string(item.value[leftPos : leftPos+size])
+			leftPos += size
+
+			if len(item.value) > leftPos {
+				leftPos += 1
+				c.mergeOperator = string(item.value[leftPos:])
+			}
+		}
 	}
 }
 
@@ -63,87 +125,210 @@ func (c *Collection) deserialize(item *Item) {
 // search, the ancestors are returned as well. This way we can iterate over them to check which nodes were modified and
 // rebalance by splitting them accordingly. If the root has too many items, then a new root of a new layer is
 // created and the created nodes from the split are added as children.
+// Keys longer than MaxKeySize and values longer than MaxValueSize are rejected.
 func (c *Collection) Put(key []byte, value []byte) error {
-	if !c.tx.write {
-		return writeInsideReadTxErr
+	_, err := c.put(key, func(current *Item) ([]byte, bool, error) {
+		return value, true, nil
+	})
+	return err
+}
+
+// PutIfAbsent puts the key only if it doesn't exist yet, and returns whether it was put.
+func (c *Collection) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	return c.put(key, func(current *Item) ([]byte, bool, error) {
+		return value, current == nil, nil
+	})
+}
+
+// CompareAndSwap replaces the value of the key with newValue only if the key exists and its value is equal to
+// oldValue, and returns whether it was replaced.
+func (c *Collection) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	return c.put(key, func(current *Item) ([]byte, bool, error) {
+		return newValue, current != nil && bytes.Equal(current.value, oldValue), nil
+	})
+}
+
+// put implements Put. The value is computed by update from the current item of the key, nil if there's none, during
+// the same descent that finds where the key is put. update also decides whether the key is put at all, and whether it
+// was put is returned. The indexes of the collection are updated along with the key, and its expiry is cleared.
+func (c *Collection) put(key []byte, update func(current *Item) ([]byte, bool, error)) (bool, error) {
+	return c.putUntil(key, time.Time{}, update)
+}
+
+// putUntil implements put, and sets the time the key expires at if it's put. A zero time means it doesn't expire.
+func (c *Collection) putUntil(key []byte, expiresAt time.Time, update func(current *Item) ([]byte, bool, error)) (bool, error) {
+	expired, err := c.isExpired(key)
+	if err != nil {
+		return false, err
 	}
 
-	i := newItem(key, value)
+	var updates []*indexUpdate
+	ok, err := c.putItem(key, func(current *Item) ([]byte, bool, error) {
+		// An expired item is absent for update, but its index entries are still replaced
+		visible := current
+		if expired {
+			visible = nil
+		}
+		value, ok, err := update(visible)
+		if err != nil || !ok {
+			return value, ok, err
+		}
+		// The index entries are checked before the key is put, so a failure leaves nothing half done
+		updates, err = c.indexUpdates(current, newItem(key, value))
+		return value, err == nil, err
+	})
+	if err != nil || !ok {
+		return ok, err
+	}
+	err = c.applyIndexUpdates(updates)
+	if err != nil {
+		return false, err
+	}
+	return true, c.setExpiry(key, expiresAt)
+}
+
+// putItem puts the key without updating the indexes.
+func (c *Collection) putItem(key []byte, update func(current *Item) ([]byte, bool, error)) (bool, error) {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return false, err
+	}
+	if len(key) > MaxKeySize {
+		return false, ErrKeyTooLarge
+	}
 
 	// On first insertion the root node does not exist, so it should be created
 	var root *Node
-	var err error
 	if c.root == 0 {
-		root = c.tx.writeNode(c.tx.newNode([]*Item{i}, []pgnum{}))
+		value, ok, err := c.updateValue(nil, update)
+		if err != nil || !ok {
+			return false, err
+		}
+		root = c.tx.newNode([]*Item{newItem(key, value)}, []pgnum{})
+		c.tx.markDirty(c, root)
 		c.root = root.pageNum
-		return nil
+		c.tx.recordChange(c, ChangePut, key, nil, value)
+		return true, c.tx.updateCollection(c)
 	} else {
 		root, err = c.tx.getNode(c.root)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
 	// Find the path to the node where the insertion should happen
-	insertionIndex, nodeToInsertIn, ancestorsIndexes, err := root.findKey(i.key, false)
+	insertionIndex, nodeToInsertIn, ancestorsIndexes, err := root.findKey(key, false, c.compare())
 	if err != nil {
-		return err
+		return false, err
 	}
 
+	var current *Item
+	exists := nodeToInsertIn.items != nil && insertionIndex < len(nodeToInsertIn.items) && c.compare()(nodeToInsertIn.items[insertionIndex].key, key) == 0
+	if exists {
+		current = nodeToInsertIn.items[insertionIndex]
+	}
+	value, ok, err := c.updateValue(current, update)
+	if err != nil || !ok {
+		return false, err
+	}
+	i := newItem(key, value)
+
 	// If key already exists
-	if nodeToInsertIn.items != nil && insertionIndex < len(nodeToInsertIn.items) && bytes.Compare(nodeToInsertIn.items[insertionIndex].key, key) == 0 {
+	if exists {
+		c.tx.recordChange(c, ChangePut, key, current.value, value)
 		nodeToInsertIn.items[insertionIndex] = i
 	} else {
 		// Add item to the leaf node
+		c.tx.recordChange(c, ChangePut, key, nil, value)
 		nodeToInsertIn.addItem(i, insertionIndex)
 	}
 	nodeToInsertIn.writeNode(nodeToInsertIn)
 
 	ancestors, err := c.getNodes(ancestorsIndexes)
 	if err != nil {
-		return err
+		return false, err
 	}
+	c.tx.markDirty(c, ancestors...)
 
 	// Rebalance the nodes all the way up. Start From one node before the last and go all the way up. Exclude root.
+	// Replacing an item with a smaller one may leave its node under populated, and it's rotated with its siblings until
+	// it's populated enough or merged into one of them, the same as after a range is deleted.
+	shrunk := exists && len(value) < len(current.value)
 	for i := len(ancestors) - 2; i >= 0; i-- {
 		pnode := ancestors[i]
 		node := ancestors[i+1]
 		nodeIndex := ancestorsIndexes[i+1]
 		if node.isOverPopulated() {
 			pnode.split(node, nodeIndex)
+			continue
+		}
+		if shrunk {
+			err = pnode.rebalanceUnderPopulated(node, nodeIndex)
+			if err != nil {
+				return false, err
+			}
 		}
 	}
 
 	// Handle root
 	rootNode := ancestors[0]
+	if len(rootNode.items) == 0 && len(rootNode.childNodes) > 0 {
+		c.root = rootNode.childNodes[0]
+		c.tx.deleteNode(rootNode)
+		return true, c.tx.updateCollection(c)
+	}
 	if rootNode.isOverPopulated() {
-		newRoot := c.tx.newNode([]*Item{}, []pgnum{rootNode.pageNum})
-		newRoot.split(rootNode, 0)
+		return true, c.splitRoot(rootNode)
+	}
 
-		// commit newly created root
-		newRoot = c.tx.writeNode(newRoot)
+	return true, nil
+}
 
-		c.root = newRoot.pageNum
+// updateValue calls update and checks the size of the value it returns.
+func (c *Collection) updateValue(current *Item, update func(current *Item) ([]byte, bool, error)) ([]byte, bool, error) {
+	value, ok, err := update(current)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if len(value) > MaxValueSize {
+		return nil, false, ErrValueTooLarge
 	}
+	return value, true, nil
+}
+
+// splitRoot splits an overpopulated root under a new root, so the tree is one level taller.
+func (c *Collection) splitRoot(rootNode *Node) error {
+	newRoot := c.tx.newNode([]*Item{}, []pgnum{rootNode.pageNum})
+	newRoot.split(rootNode, 0)
+
+	// commit newly created root
+	newRoot = c.tx.writeNode(newRoot)
 
-	return nil
+	c.root = newRoot.pageNum
+	return c.tx.updateCollection(c)
 }
 
-// Find Returns an item according based on the given key by performing a binary search.
+// Find Returns an item according based on the given key by performing a binary search. Expired items aren't returned.
 func (c *Collection) Find(key []byte) (*Item, error) {
 	n, err := c.tx.getNode(c.root)
 	if err != nil {
 		return nil, err
 	}
 
-	index, containingNode, _, err := n.findKey(key, true)
+	index, containingNode, _, err := n.findKey(key, true, c.compare())
 	if err != nil {
 		return nil, err
 	}
 	if index == -1 {
 		return nil, nil
 	}
-	return containingNode.items[index], nil
+
+	item := containingNode.items[index]
+	expired, err := c.isExpired(item.key)
+	if err != nil || expired {
+		return nil, err
+	}
+	return item, nil
 }
 
 // Remove removes a key from the tree. It finds the correct node and the index to remove the item from and removes it.
@@ -152,23 +337,78 @@ func (c *Collection) Find(key []byte) (*Item, error) {
 // siblings don't have enough items, then merging occurs. If the root is without items after a split, then the root is
 // removed and the tree is one level shorter.
 func (c *Collection) Remove(key []byte) error {
-	if !c.tx.write {
-		return writeInsideReadTxErr
+	_, err := c.remove(key, nil, false)
+	return err
+}
+
+// DeleteIfEquals removes the key only if its value is equal to the given value, and returns whether it was removed.
+func (c *Collection) DeleteIfEquals(key []byte, value []byte) (bool, error) {
+	return c.remove(key, func(current *Item) bool {
+		return bytes.Equal(current.value, value)
+	}, false)
+}
+
+// remove implements Remove. If a condition is given, it's checked against the item of the key during the same descent
+// that finds it. The key is removed only if it exists and the condition holds, and whether it was removed is returned.
+// The indexes of the collection are updated along with the key, and its expiry is cleared. An expired item is removed
+// only if there's no condition, since it's treated as absent. For the same reason, its removal is reported to watchers
+// only if reportExpired is set, which is when it's removed because it expired.
+func (c *Collection) remove(key []byte, condition func(current *Item) bool, reportExpired bool) (bool, error) {
+	expired, err := c.isExpired(key)
+	if err != nil {
+		return false, err
+	}
+
+	var updates []*indexUpdate
+	ok, removeErr := c.removeItem(key, func(current *Item) bool {
+		if condition != nil && (expired || !condition(current)) {
+			return false
+		}
+		updates, err = c.indexUpdates(current, nil)
+		if err != nil {
+			return false
+		}
+		if !expired || reportExpired {
+			c.tx.recordChange(c, ChangeDelete, key, current.value, nil)
+		}
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	if removeErr != nil || !ok {
+		return ok, removeErr
+	}
+	err = c.applyIndexUpdates(updates)
+	if err != nil {
+		return false, err
+	}
+	return true, c.setExpiry(key, time.Time{})
+}
+
+// removeItem removes the key without updating the indexes.
+func (c *Collection) removeItem(key []byte, condition func(current *Item) bool) (bool, error) {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return false, err
 	}
 
 	// Find the path to the node where the deletion should happen
 	rootNode, err := c.tx.getNode(c.root)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	removeItemIndex, nodeToRemoveFrom, ancestorsIndexes, err := rootNode.findKey(key, true)
+	removeItemIndex, nodeToRemoveFrom, ancestorsIndexes, err := rootNode.findKey(key, true, c.compare())
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if removeItemIndex == -1 {
-		return nil
+		return false, nil
+	}
+	if condition != nil && !condition(nodeToRemoveFrom.items[removeItemIndex]) {
+		return false, nil
 	}
 
 	if nodeToRemoveFrom.isLeaf() {
@@ -176,35 +416,45 @@ func (c *Collection) Remove(key []byte) error {
 	} else {
 		affectedNodes, err := nodeToRemoveFrom.removeItemFromInternal(removeItemIndex)
 		if err != nil {
-			return err
+			return false, err
 		}
 		ancestorsIndexes = append(ancestorsIndexes, affectedNodes...)
 	}
 
 	ancestors, err := c.getNodes(ancestorsIndexes)
 	if err != nil {
-		return err
+		return false, err
 	}
+	c.tx.markDirty(c, ancestors...)
 
 	// Rebalance the nodes all the way up. Start From one node before the last and go all the way up. Exclude root.
+	// Items have different sizes, so a node on the path may also grow when one of its items is replaced by a bigger
+	// one, either the predecessor of a removed item or an item rotated from a sibling. Such a node is split.
 	for i := len(ancestors) - 2; i >= 0; i-- {
 		pnode := ancestors[i]
 		node := ancestors[i+1]
 		if node.isUnderPopulated() {
-			err = pnode.rebalanceRemove(node, ancestorsIndexes[i+1])
+			err = pnode.rebalanceUnderPopulated(node, ancestorsIndexes[i+1])
 			if err != nil {
-				return err
+				return false, err
 			}
+		} else if node.isOverPopulated() {
+			pnode.split(node, ancestorsIndexes[i+1])
 		}
 	}
 
 	rootNode = ancestors[0]
 	// If the root has no items after rebalancing, there's no need to save it because we ignore it.
 	if len(rootNode.items) == 0 && len(rootNode.childNodes) > 0 {
-		c.root = ancestors[1].pageNum
+		c.root = rootNode.childNodes[0]
+		c.tx.deleteNode(rootNode)
+		return true, c.tx.updateCollection(c)
+	}
+	if rootNode.isOverPopulated() {
+		return true, c.splitRoot(rootNode)
 	}
 
-	return nil
+	return true, nil
 }
 
 // getNodes returns a list of nodes based on their indexes (the breadcrumbs) from the root
@@ -1,8 +1,11 @@
 package LibraDB
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math/rand"
 	"os"
 	"testing"
 )
@@ -165,3 +168,281 @@ func TestDeserializeCollection(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, expected, actual)
 }
+
+// fuzzOps reads operations from the fuzzer input. Keys and values are a byte repeated up to 255 times, so short
+// inputs still produce large items and deep trees.
+type fuzzOps struct {
+	data []byte
+}
+
+func (o *fuzzOps) next() (byte, bool) {
+	if len(o.data) == 0 {
+		return 0, false
+	}
+	b := o.data[0]
+	o.data = o.data[1:]
+	return b, true
+}
+
+func (o *fuzzOps) nextBytes() ([]byte, bool) {
+	b, ok := o.next()
+	if !ok {
+		return nil, false
+	}
+	n, ok := o.next()
+	if !ok {
+		return nil, false
+	}
+	return bytes.Repeat([]byte{b}, int(n)), true
+}
+
+func requireCollectionMatches(t *testing.T, db *DB, expected map[string]string) {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	actual := map[string]string{}
+	cursor := collection.Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil && err == nil; key, value, err = cursor.Next() {
+		actual[string(key)] = string(value)
+	}
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	for key, value := range expected {
+		item, err := collection.Find([]byte(key))
+		require.NoError(t, err)
+		require.NotNil(t, item)
+		require.Equal(t, value, string(item.value))
+	}
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%v", report.Violations)
+}
+
+// FuzzCollection applies random Put and Remove sequences to a collection and compares it with a map after every
+// commit and rollback. The tree invariants are checked as well.
+func FuzzCollection(f *testing.F) {
+	f.Add([]byte{0, 'a', 1, 'b', 1, 2})
+	f.Add(bytes.Repeat([]byte{0, 'k', 255, 'v', 255, 0, 'j', 200, 'w', 255, 0, 'l', 255, 'x', 100, 1, 'k', 255}, 20))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		db, err := OpenStorage(NewMemoryStorage(), &Options{MinFillPercent: 0.5, MaxFillPercent: 0.95})
+		require.NoError(t, err)
+		defer db.Close()
+
+		tx := db.WriteTx()
+		_, err = tx.CreateCollection(testCollectionName)
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		committed := map[string]string{}
+		current := map[string]string{}
+		tx = db.WriteTx()
+		collection, err := tx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+
+		ops := &fuzzOps{data}
+		for {
+			op, ok := ops.next()
+			if !ok {
+				break
+			}
+
+			switch op % 4 {
+			case 0:
+				key, ok := ops.nextBytes()
+				if !ok {
+					break
+				}
+				value, ok := ops.nextBytes()
+				if !ok {
+					break
+				}
+				require.NoError(t, collection.Put(key, value))
+				current[string(key)] = string(value)
+			case 1:
+				key, ok := ops.nextBytes()
+				if !ok {
+					break
+				}
+				require.NoError(t, collection.Remove(key))
+				delete(current, string(key))
+			case 2, 3:
+				if op%4 == 2 {
+					require.NoError(t, tx.Commit())
+					committed = current
+				} else {
+					tx.Rollback()
+				}
+				requireCollectionMatches(t, db, committed)
+
+				current = map[string]string{}
+				for key, value := range committed {
+					current[key] = value
+				}
+				tx = db.WriteTx()
+				collection, err = tx.GetCollection(testCollectionName)
+				require.NoError(t, err)
+			}
+		}
+
+		require.NoError(t, tx.Commit())
+		requireCollectionMatches(t, db, current)
+	})
+}
+
+func TestCollection_PutAndRemoveLargeItems(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), &Options{MinFillPercent: 0.5, MaxFillPercent: 0.95})
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	// Keys of different sizes, so merges and rotations move items of different sizes between nodes
+	expected := map[string]string{}
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 300; i++ {
+		key := bytes.Repeat([]byte{byte(i)}, 1+r.Intn(MaxKeySize))
+		value := bytes.Repeat([]byte{'v'}, r.Intn(MaxValueSize+1))
+		require.NoError(t, collection.Put(key, value))
+		expected[string(key)] = string(value)
+	}
+	require.NoError(t, tx.Commit())
+	requireCollectionMatches(t, db, expected)
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for key := range expected {
+		if r.Intn(4) != 0 {
+			require.NoError(t, collection.Remove([]byte(key)))
+			delete(expected, key)
+		}
+	}
+	require.NoError(t, tx.Commit())
+	requireCollectionMatches(t, db, expected)
+}
+
+func TestCollection_PutTooLarge(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, collection.Put(make([]byte, MaxKeySize+1), nil), ErrKeyTooLarge)
+	assert.ErrorIs(t, collection.Put([]byte("key"), make([]byte, MaxValueSize+1)), ErrValueTooLarge)
+}
+
+func TestCollection_ConditionalWrites(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	ok, err := collection.CompareAndSwap(key, nil, []byte("v1"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = collection.PutIfAbsent(key, []byte("v1"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = collection.PutIfAbsent(key, []byte("v2"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = collection.CompareAndSwap(key, []byte("v2"), []byte("v3"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = collection.CompareAndSwap(key, []byte("v1"), []byte("v2"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	item, err := collection.Find(key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), item.value)
+
+	ok, err = collection.DeleteIfEquals(key, []byte("v1"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = collection.DeleteIfEquals([]byte("missing"), nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = collection.DeleteIfEquals(key, []byte("v2"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	item, err = collection.Find(key)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestCollection_ConditionalWritesManyKeys(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	// Enough keys for a multi level tree, so keys are found in internal nodes as well
+	for i := 0; i < 200; i++ {
+		ok, err := collection.PutIfAbsent([]byte(fmt.Sprintf("key%03d", i)), []byte("v1"))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	for i := 0; i < 200; i++ {
+		ok, err := collection.CompareAndSwap([]byte(fmt.Sprintf("key%03d", i)), []byte("v1"), []byte("v2"))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	for i := 0; i < 200; i += 2 {
+		ok, err := collection.DeleteIfEquals([]byte(fmt.Sprintf("key%03d", i)), []byte("v2"))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	require.NoError(t, tx.Commit())
+
+	expected := map[string]string{}
+	for i := 1; i < 200; i += 2 {
+		expected[fmt.Sprintf("key%03d", i)] = "v2"
+	}
+	requireCollectionMatches(t, db, expected)
+}
+
+func TestCollection_ConditionalWritesReadTx(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	readTx := db.ReadTx()
+	defer readTx.Rollback()
+	collection, err := readTx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	_, err = collection.PutIfAbsent([]byte("key"), []byte("value"))
+	assert.ErrorIs(t, err, writeInsideReadTxErr)
+	_, err = collection.CompareAndSwap([]byte("key"), nil, []byte("value"))
+	assert.ErrorIs(t, err, writeInsideReadTxErr)
+	_, err = collection.DeleteIfEquals([]byte("key"), nil)
+	assert.ErrorIs(t, err, writeInsideReadTxErr)
+}
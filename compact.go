@@ -0,0 +1,272 @@
+package LibraDB
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrBackupInProgress = errors.New("the database can't be compacted while a backup is running")
+
+// Compact shrinks the database file. Pages released by deletes are reused for new nodes, but the file itself never
+// shrinks, so over time it fills with holes. Compact relocates the live pages found at the tail of the file into those
+// holes, rewrites the pointers to them and truncates the file right after the last live page. Pages that aren't
+// reachable from the meta page are considered free. The number of bytes reclaimed is returned.
+// Like commits, Compact never overwrites the pages of the last committed state, so a crash in the middle of it leaves
+// the database as it was before, after or half way through the compaction, with the same content. It returns
+// ErrBackupInProgress while a backup is running.
+func (db *DB) Compact() (int64, error) {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	// Compacting moves live pages into free pages, which may still be copied by a backup
+	if db.isPinned() {
+		return 0, ErrBackupInProgress
+	}
+	return db.compact()
+}
+
+func (d *dal) compact() (int64, error) {
+	reclaimed, err := d.compactPages()
+	if err != nil {
+		// The pages of the last committed state were never overwritten, the same as after a failed commit.
+		_ = d.reload()
+	}
+	return reclaimed, err
+}
+
+// compactPages relocates the pages in two steps, each committed like a transaction. The nodes that move and their
+// ancestors are first copied to the end of the file, so neither their pages nor the pages they move to are used
+// anymore. They are then copied again to their final pages, and the file is truncated.
+func (d *dal) compactPages() (int64, error) {
+	oldSize, err := d.size()
+	if err != nil {
+		return 0, err
+	}
+
+	pages, err := d.livePages(d.meta)
+	if err != nil {
+		return 0, err
+	}
+	// The freelist is empty once compacted, so it fits in its first page and the other pages are dropped.
+	for _, pageNum := range d.overflowPages {
+		delete(pages, pageNum)
+	}
+
+	// Live pages are packed into [1, newMaxPage]. Every live page above it is moved into a free page below it. The
+	// number of free pages below newMaxPage is exactly the number of live pages above it.
+	newMaxPage := pgnum(len(pages))
+	if newMaxPage == d.maxPage {
+		return 0, nil
+	}
+	var moved, holes []pgnum
+	for pageNum := range pages {
+		if pageNum > newMaxPage {
+			moved = append(moved, pageNum)
+		}
+	}
+	for pageNum := pgnum(1); pageNum <= newMaxPage; pageNum++ {
+		if _, ok := pages[pageNum]; !ok {
+			holes = append(holes, pageNum)
+		}
+	}
+	sort.Slice(moved, func(i, j int) bool { return moved[i] < moved[j] })
+
+	relocated := make(map[pgnum]pgnum, len(moved))
+	for i, pageNum := range moved {
+		relocated[pageNum] = holes[i]
+	}
+	remap := func(pageNum pgnum) pgnum {
+		if newPageNum, ok := relocated[pageNum]; ok {
+			return newPageNum
+		}
+		return pageNum
+	}
+
+	// Every node that moves or points to a page that moves is rewritten.
+	changed := map[pgnum]bool{}
+	_, err = d.findChanged(d.root, true, newMaxPage, changed)
+	if err != nil {
+		return 0, err
+	}
+	root, freelistPage := d.root, d.freelistPage
+
+	// The first copies are appended to the file, followed by a freelist holding every page that isn't used by them.
+	copies := make(map[pgnum]pgnum, len(changed))
+	lastPage := d.maxPage
+	for pageNum := range changed {
+		lastPage++
+		copies[pageNum] = lastPage
+	}
+	copiedRoot, err := d.writeRelocated(root, true, changed, copies)
+	if err != nil {
+		return 0, err
+	}
+
+	used := make(map[pgnum]bool, len(pages))
+	for pageNum, kind := range pages {
+		if kind == freelistPageKind {
+			continue
+		}
+		if copied, ok := copies[pageNum]; ok {
+			pageNum = copied
+		}
+		used[pageNum] = true
+	}
+	d.releasedPages = []pgnum{}
+	for pageNum := pgnum(1); pageNum <= lastPage; pageNum++ {
+		if !used[pageNum] {
+			d.releasedPages = append(d.releasedPages, pageNum)
+		}
+	}
+	d.freelistPage = lastPage + 1
+	d.overflowPages = nil
+	d.maxPage = d.freelistPage
+	for (len(d.overflowPages)+1)*pageCapacity(d.pageSize) < len(d.releasedPages) {
+		d.maxPage++
+		d.overflowPages = append(d.overflowPages, d.maxPage)
+	}
+	err = d.commitCompaction(copiedRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	// The final copies go to the pages the nodes moved to, and to the pages of the nodes that didn't move, which are
+	// all free now.
+	final := make(map[pgnum]pgnum, len(changed))
+	for pageNum := range changed {
+		final[pageNum] = remap(pageNum)
+	}
+	finalRoot, err := d.writeRelocated(root, true, changed, final)
+	if err != nil {
+		return 0, err
+	}
+	d.freelistPage = remap(freelistPage)
+	d.overflowPages = nil
+	d.maxPage = newMaxPage
+	d.releasedPages = []pgnum{}
+	err = d.commitCompaction(finalRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	// The file grew by the first copies, so it's truncated even if nothing was reclaimed.
+	newSize := int64(newMaxPage+1) * int64(d.pageSize)
+	err = d.truncate(newSize)
+	if err != nil || newSize >= oldSize {
+		return 0, err
+	}
+	return oldSize - newSize, nil
+}
+
+// findChanged adds the nodes of the tree rooted at the given page that are above newMaxPage, or that have such a
+// descendant, to changed. The trees of the collections are descendants of the root collection. It returns whether the
+// node itself was added.
+func (d *dal) findChanged(pageNum pgnum, isRootCollection bool, newMaxPage pgnum, changed map[pgnum]bool) (bool, error) {
+	node, err := d.getNode(pageNum)
+	if err != nil {
+		return false, err
+	}
+
+	isChanged := pageNum > newMaxPage
+	for _, childNode := range node.childNodes {
+		childChanged, err := d.findChanged(childNode, isRootCollection, newMaxPage, changed)
+		if err != nil {
+			return false, err
+		}
+		isChanged = isChanged || childChanged
+	}
+
+	if isRootCollection {
+		for _, item := range node.items {
+			if !isCollectionKey(item.key) {
+				continue
+			}
+			collection := newEmptyCollection()
+			collection.deserialize(item)
+			if collection.root == 0 {
+				continue
+			}
+			collectionChanged, err := d.findChanged(collection.root, false, newMaxPage, changed)
+			if err != nil {
+				return false, err
+			}
+			isChanged = isChanged || collectionChanged
+		}
+	}
+
+	if isChanged {
+		changed[pageNum] = true
+	}
+	return isChanged, nil
+}
+
+// writeRelocated writes the changed nodes of the tree rooted at the given page to their pages in locations, pointing
+// to the new pages of their children, in post order. The nodes are read from their current pages, which the locations
+// never overwrite before they are read. It returns the page the node is at.
+func (d *dal) writeRelocated(pageNum pgnum, isRootCollection bool, changed map[pgnum]bool, locations map[pgnum]pgnum) (pgnum, error) {
+	if !changed[pageNum] {
+		return pageNum, nil
+	}
+
+	node, err := d.getNode(pageNum)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, childNode := range node.childNodes {
+		node.childNodes[i], err = d.writeRelocated(childNode, isRootCollection, changed, locations)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// The values of the root collection hold the root pages of the collections.
+	if isRootCollection {
+		for i, item := range node.items {
+			if !isCollectionKey(item.key) {
+				continue
+			}
+			collection := newEmptyCollection()
+			collection.deserialize(item)
+			if collection.root == 0 {
+				continue
+			}
+			newRoot, err := d.writeRelocated(collection.root, false, changed, locations)
+			if err != nil {
+				return 0, err
+			}
+			if newRoot != collection.root {
+				collection.root = newRoot
+				node.items[i] = collection.serialize()
+			}
+		}
+	}
+
+	node.pageNum = locations[pageNum]
+	_, err = d.writeNode(node)
+	if err != nil {
+		return 0, err
+	}
+	return node.pageNum, nil
+}
+
+// commitCompaction writes the freelist and then the meta page pointing to the given root collection, syncing before
+// and after the meta page the same way commits do.
+func (d *dal) commitCompaction(root pgnum) error {
+	err := d.writeFreelist()
+	if err != nil {
+		return err
+	}
+	err = d.sync()
+	if err != nil {
+		return err
+	}
+
+	d.root = root
+	d.txid += 1
+	_, err = d.writeMeta(d.meta)
+	if err != nil {
+		return err
+	}
+	return d.sync()
+}
@@ -0,0 +1,135 @@
+package LibraDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDB_Compact(t *testing.T) {
+	path := getTempFileName()
+	db, err := Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		val := createItem(strconv.Itoa(i % 10))[:testValSize-3]
+		val = append(val, []byte(strconv.Itoa(100+i))...)
+		require.NoError(t, collection.Put(val, val))
+	}
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 5; i < 100; i++ {
+		val := createItem(strconv.Itoa(i % 10))[:testValSize-3]
+		val = append(val, []byte(strconv.Itoa(100+i))...)
+		require.NoError(t, collection.Remove(val))
+	}
+	require.NoError(t, tx.Commit())
+
+	sizeBefore, err := db.size()
+	require.NoError(t, err)
+
+	reclaimed, err := db.Compact()
+	require.NoError(t, err)
+	assert.Greater(t, reclaimed, int64(0))
+
+	sizeAfter, err := db.size()
+	require.NoError(t, err)
+	assert.Equal(t, sizeBefore-reclaimed, sizeAfter)
+	assert.Equal(t, int64(db.maxPage+1)*int64(db.pageSize), sizeAfter)
+	assert.Empty(t, db.releasedPages)
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		val := createItem(strconv.Itoa(i % 10))[:testValSize-3]
+		val = append(val, []byte(strconv.Itoa(100+i))...)
+		item, err := collection.Find(val)
+		require.NoError(t, err)
+		if i < 5 {
+			require.NotNil(t, item)
+			assert.Equal(t, val, item.value)
+		} else {
+			assert.Nil(t, item)
+		}
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestDB_CompactNothingToReclaim(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// The pages the commit copied from are reclaimed first
+	_, err = db.Compact()
+	require.NoError(t, err)
+
+	reclaimed, err := db.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reclaimed)
+}
+
+func TestDB_CompactCrash(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	options := &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage}
+
+	// Every write and sync of the compaction is failed in turn, and the database is reopened after a crash.
+	for failAt := 0; ; failAt++ {
+		storage := newFaultStorage()
+		db, err := OpenStorage(storage, options)
+		require.NoError(t, err)
+
+		tx := db.WriteTx()
+		for _, name := range []string{"a", "b"} {
+			collection, err := tx.CreateCollection([]byte(name))
+			require.NoError(t, err)
+			putRangeTestKeys(t, collection, 300)
+		}
+		require.NoError(t, tx.Commit())
+		tx = db.WriteTx()
+		collection, err := tx.GetCollection([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, collection.DeleteRange(rangeTestKey(20), rangeTestKey(280)))
+		require.NoError(t, tx.Commit())
+		expected := readCrashState(t, db)
+
+		storage.failAt = failAt
+		storage.tear = failAt%2 == 0
+		reclaimed, compactErr := db.Compact()
+		storage.crash(r)
+
+		db, err = OpenStorage(storage, options)
+		require.NoError(t, err, "failed at %d", failAt)
+		require.Equal(t, expected, readCrashState(t, db), "failed at %d", failAt)
+		report, err := db.Check()
+		require.NoError(t, err)
+		require.True(t, report.OK(), "failed at %d: %v", failAt, report.Violations)
+		require.NoError(t, db.Close())
+
+		if compactErr == nil {
+			assert.Greater(t, reclaimed, int64(0))
+			break
+		}
+	}
+}
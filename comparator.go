@@ -0,0 +1,84 @@
+package LibraDB
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrUnknownComparator = errors.New("the comparator of the collection isn't registered in Options.Comparators")
+	ErrComparatorName    = errors.New("the comparator name is too long")
+)
+
+// Comparator orders the keys of a collection. It returns a negative number if a comes before b, 0 if they are the same
+// key and a positive number if a comes after b. Comparators must be registered by name in Options.Comparators every
+// time the database is opened, as only the name is stored with the collection.
+type Comparator func(a []byte, b []byte) int
+
+// maxComparatorNameSize is the longest comparator name. The names of the comparator and the merge operator are stored
+// with the collection in the value of its entry, each prefixed by its length, so both fit with the longest names.
+const maxComparatorNameSize = (MaxValueSize - collectionSize - 2) / 2
+
+// CollectionOptions holds the options of a collection that are set when it's created.
+type CollectionOptions struct {
+	// Comparator is the name of the comparator ordering the keys, registered in Options.Comparators. Keys are ordered
+	// by bytes.Compare if it's empty.
+	Comparator string
+}
+
+// CreateCollectionWithOptions creates a collection with the given options.
+func (tx *tx) CreateCollectionWithOptions(name []byte, options *CollectionOptions) (*Collection, error) {
+	err := tx.checkWrite()
+	if err != nil {
+		return nil, err
+	}
+	err = checkCollectionName(name)
+	if err != nil {
+		return nil, err
+	}
+	return tx.createCollectionWithOptions(name, options)
+}
+
+// createCollectionWithOptions creates a collection without checking its name, so it's used for hidden collections as
+// well. The names of the comparator and the merge operator registered for the collection are checked, so they can be
+// stored with it.
+func (tx *tx) createCollectionWithOptions(name []byte, options *CollectionOptions) (*Collection, error) {
+	if len(options.Comparator) > maxComparatorNameSize {
+		return nil, ErrComparatorName
+	}
+	if operator, ok := tx.db.mergeOperators[string(name)]; ok && len(operator.Name()) > maxMergeOperatorNameSize {
+		return nil, ErrMergeOperatorName
+	}
+
+	newCollection := newEmptyCollection()
+	newCollection.name = name
+	newCollection.comparator = options.Comparator
+	newCollection.tx = tx
+	err := newCollection.checkComparator()
+	if err != nil {
+		return nil, err
+	}
+
+	newCollectionPage := tx.writeNode(tx.newNode([]*Item{}, []pgnum{}))
+	newCollection.root = newCollectionPage.pageNum
+	return tx.createCollection(newCollection)
+}
+
+// checkComparator returns ErrUnknownComparator if the comparator of the collection isn't registered.
+func (c *Collection) checkComparator() error {
+	if c.comparator == "" {
+		return nil
+	}
+	if _, ok := c.tx.db.comparators[c.comparator]; !ok {
+		return ErrUnknownComparator
+	}
+	return nil
+}
+
+// compare returns the comparator ordering the keys of the collection.
+func (c *Collection) compare() Comparator {
+	if c.comparator == "" {
+		return bytes.Compare
+	}
+	return c.tx.db.comparators[c.comparator]
+}
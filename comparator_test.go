@@ -0,0 +1,208 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func caseInsensitiveCompare(a []byte, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+
+func numericCompare(a []byte, b []byte) int {
+	x, _ := strconv.Atoi(string(a))
+	y, _ := strconv.Atoi(string(b))
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func comparatorTestOptions() *Options {
+	return &Options{
+		MinFillPercent: testMinPercentage,
+		MaxFillPercent: testMaxPercentage,
+		Comparators: map[string]Comparator{
+			"case-insensitive": caseInsensitiveCompare,
+			"numeric":          numericCompare,
+		},
+	}
+}
+
+func TestCollection_CaseInsensitiveComparator(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), comparatorTestOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: "case-insensitive"})
+	require.NoError(t, err)
+
+	require.NoError(t, collection.Put([]byte("Bob"), []byte("1")))
+	require.NoError(t, collection.Put([]byte("alice"), []byte("2")))
+	require.NoError(t, collection.Put([]byte("ALICE"), []byte("3")))
+
+	item, err := collection.Find([]byte("Alice"))
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	assert.Equal(t, []byte("ALICE"), item.key)
+	assert.Equal(t, []byte("3"), item.value)
+
+	key, _, err := collection.Cursor().Seek([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("Bob"), key)
+
+	require.NoError(t, collection.Remove([]byte("bob")))
+	item, err = collection.Find([]byte("Bob"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestCollection_NumericComparator(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), comparatorTestOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: "numeric"})
+	require.NoError(t, err)
+	// Enough keys for a multi level tree
+	for _, i := range rand.New(rand.NewSource(1)).Perm(300) {
+		require.NoError(t, collection.Put([]byte(strconv.Itoa(i)), []byte("value")))
+	}
+	for i := 0; i < 300; i += 3 {
+		require.NoError(t, collection.Remove([]byte(strconv.Itoa(i))))
+	}
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	var keys []string
+	cursor := collection.Cursor()
+	for key, _, err := cursor.First(); key != nil; key, _, err = cursor.Next() {
+		require.NoError(t, err)
+		keys = append(keys, string(key))
+	}
+	tx.Rollback()
+
+	var expected []string
+	for i := 0; i < 300; i++ {
+		if i%3 != 0 {
+			expected = append(expected, fmt.Sprint(i))
+		}
+	}
+	assert.Equal(t, expected, keys)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations)
+}
+
+func TestCollection_ComparatorMustBeRegistered(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	db, err := Open(path, comparatorTestOptions())
+	require.NoError(t, err)
+
+	tx := db.WriteTx()
+	_, err = tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: "unknown"})
+	assert.ErrorIs(t, err, ErrUnknownComparator)
+	collection, err := tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: "numeric"})
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("10"), []byte("value")))
+	require.NoError(t, collection.Put([]byte("9"), []byte("value")))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// The comparator name is stored with the collection
+	db, err = Open(path, comparatorTestOptions())
+	require.NoError(t, err)
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	key, _, err := collection.Cursor().First()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("9"), key)
+	tx.Rollback()
+	require.NoError(t, db.Close())
+
+	db, err = Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer db.Close()
+	tx = db.ReadTx()
+	_, err = tx.GetCollection(testCollectionName)
+	assert.ErrorIs(t, err, ErrUnknownComparator)
+	_, err = tx.Collections()
+	assert.ErrorIs(t, err, ErrUnknownComparator)
+	tx.Rollback()
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	require.Len(t, report.Violations, 1)
+	assert.Equal(t, CollectionViolation, report.Violations[0].Kind)
+}
+
+func TestCollection_ComparatorAndMergeOperatorNameSize(t *testing.T) {
+	comparator := strings.Repeat("c", maxComparatorNameSize)
+	operator := strings.Repeat("m", maxMergeOperatorNameSize)
+	options := comparatorTestOptions()
+	options.Comparators[comparator] = bytes.Compare
+	options.Comparators[comparator+"c"] = bytes.Compare
+	options.MergeOperators = map[string]MergeOperator{
+		"both": NewMergeOperator(operator, mergeAppend),
+		"long": NewMergeOperator(operator+"m", mergeAppend),
+	}
+	db, err := OpenStorage(NewMemoryStorage(), options)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	_, err = tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: comparator + "c"})
+	assert.ErrorIs(t, err, ErrComparatorName)
+	_, err = tx.CreateCollection([]byte("long"))
+	assert.ErrorIs(t, err, ErrMergeOperatorName)
+
+	// The longest names are stored together
+	collection, err := tx.CreateCollectionWithOptions([]byte("both"), &CollectionOptions{Comparator: comparator})
+	require.NoError(t, err)
+	require.NoError(t, collection.Merge([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection([]byte("both"))
+	require.NoError(t, err)
+	assert.Equal(t, comparator, collection.comparator)
+	assert.Equal(t, operator, collection.mergeOperator)
+}
+
+func TestSerializeCollectionWithComparator(t *testing.T) {
+	collection := &Collection{
+		name:       []byte("collection1"),
+		root:       1,
+		counter:    2,
+		comparator: "numeric",
+	}
+
+	item := collection.serialize()
+	assert.True(t, isValidCollectionValue(item.value))
+	actual := newEmptyCollection()
+	actual.deserialize(item)
+	assert.Equal(t, collection, actual)
+
+	assert.False(t, isValidCollectionValue(item.value[:len(item.value)-1]))
+}
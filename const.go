@@ -4,11 +4,37 @@ import "errors"
 
 const (
 	magicNumberSize = 4
-	counterSize = 4
+	counterSize = 8
 	nodeHeaderSize = 3
+	itemOverheadSize = 4
 
 	collectionSize = 16
 	pageNumSize    = 8
+
+	txidSize     = 8
+	checksumSize = 4
+	metaSize     = magicNumberSize + 2*pageNumSize + txidSize + checksumSize
+
+	// The lower 16 bits of the max page and the number of released pages a freelist page holds
+	freelistHeaderSize = 4
+	// The page the freelist continues at and the full max page, which follow the released pages
+	freelistTrailerSize = 2 * pageNumSize
+
+	// The lengths of keys and values are stored in a single byte in the page
+	MaxKeySize   = 255
+	MaxValueSize = 255
+
+	// maxElementSize is the size of the biggest item a node can hold, including its child pointer
+	maxElementSize = MaxKeySize + MaxValueSize + itemOverheadSize + pageNumSize
 )
 
-var writeInsideReadTxErr = errors.New("can't perform a write operation inside a read transaction")
\ No newline at end of file
+var (
+	writeInsideReadTxErr = errors.New("can't perform a write operation inside a read transaction")
+	invalidMetaErr       = errors.New("the file is not a libra db file or its meta page is corrupted")
+	freelistTooBigErr    = errors.New("the freelist doesn't fit in its pages")
+	corruptedPageErr     = errors.New("the page is corrupted")
+
+	ErrTxClosed      = errors.New("the transaction was already committed or rolled back")
+	ErrKeyTooLarge   = errors.New("key is too large")
+	ErrValueTooLarge = errors.New("value is too large")
+)
\ No newline at end of file
@@ -0,0 +1,343 @@
+package LibraDB
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var errInjectedFault = errors.New("injected fault")
+
+// storageOp is a write or a truncate that reached the storage but wasn't synced yet.
+type storageOp struct {
+	off      int64
+	data     []byte
+	truncate bool
+}
+
+// faultStorage is a Storage that simulates a disk with a volatile cache. Writes are visible to reads right away but
+// become durable only on Sync. It can fail the Nth WriteAt or Sync, optionally tearing the failed write, and after
+// that every operation fails as if the disk was gone. crash simulates a power loss: an arbitrary subset of the unsynced
+// writes survives, some of them possibly torn, and the rest is lost.
+type faultStorage struct {
+	synced  []byte
+	current []byte
+	pending []storageOp
+
+	// failAt is the number of WriteAt and Sync calls left until one fails. A negative value never fails.
+	failAt int
+	tear   bool
+	failed bool
+	locked bool
+}
+
+func newFaultStorage() *faultStorage {
+	return &faultStorage{failAt: -1}
+}
+
+func applyStorageOp(data []byte, op storageOp) []byte {
+	if op.truncate {
+		if op.off < int64(len(data)) {
+			return data[:op.off]
+		}
+		return append(data, make([]byte, op.off-int64(len(data)))...)
+	}
+	if end := op.off + int64(len(op.data)); end > int64(len(data)) {
+		data = append(data, make([]byte, end-int64(len(data)))...)
+	}
+	copy(data[op.off:], op.data)
+	return data
+}
+
+// injectFault counts down to the failing operation. It returns true if the current operation should fail.
+func (s *faultStorage) injectFault() bool {
+	if s.failed {
+		return true
+	}
+	if s.failAt < 0 {
+		return false
+	}
+	if s.failAt == 0 {
+		s.failed = true
+		return true
+	}
+	s.failAt--
+	return false
+}
+
+func (s *faultStorage) ReadAt(p []byte, off int64) (int, error) {
+	if s.failed {
+		return 0, errInjectedFault
+	}
+	if off >= int64(len(s.current)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.current[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *faultStorage) WriteAt(p []byte, off int64) (int, error) {
+	if s.injectFault() {
+		if !s.tear || len(p) == 0 {
+			return 0, errInjectedFault
+		}
+		// Only a prefix of the page made it to the disk
+		s.tear = false
+		p = p[:len(p)/2]
+		s.pending = append(s.pending, storageOp{off: off, data: append([]byte{}, p...)})
+		s.current = applyStorageOp(s.current, s.pending[len(s.pending)-1])
+		return len(p), errInjectedFault
+	}
+
+	op := storageOp{off: off, data: append([]byte{}, p...)}
+	s.pending = append(s.pending, op)
+	s.current = applyStorageOp(s.current, op)
+	return len(p), nil
+}
+
+func (s *faultStorage) Sync() error {
+	if s.injectFault() {
+		return errInjectedFault
+	}
+	s.synced = append(s.synced[:0], s.current...)
+	s.pending = nil
+	return nil
+}
+
+func (s *faultStorage) Truncate(size int64) error {
+	if s.failed {
+		return errInjectedFault
+	}
+	op := storageOp{off: size, truncate: true}
+	s.pending = append(s.pending, op)
+	s.current = applyStorageOp(s.current, op)
+	return nil
+}
+
+func (s *faultStorage) Size() (int64, error) {
+	if s.failed {
+		return 0, errInjectedFault
+	}
+	return int64(len(s.current)), nil
+}
+
+func (s *faultStorage) Lock() error {
+	if s.locked {
+		return ErrDatabaseLocked
+	}
+	s.locked = true
+	return nil
+}
+
+func (s *faultStorage) Close() error {
+	s.locked = false
+	return nil
+}
+
+// crash drops the volatile state. Every unsynced operation is either lost, kept or, for writes, torn. The storage
+// is unlocked and stops failing, so the database can be opened again.
+func (s *faultStorage) crash(r *rand.Rand) {
+	data := append([]byte{}, s.synced...)
+	for _, op := range s.pending {
+		switch r.Intn(3) {
+		case 0:
+			continue
+		case 1:
+			if !op.truncate && len(op.data) > 0 {
+				op.data = op.data[:r.Intn(len(op.data))]
+			}
+		}
+		data = applyStorageOp(data, op)
+	}
+
+	s.synced = data
+	s.current = append([]byte{}, data...)
+	s.pending = nil
+	s.failAt = -1
+	s.tear = false
+	s.failed = false
+	s.locked = false
+}
+
+// crashState is the content of a database: collection name to key to value.
+type crashState map[string]map[string]string
+
+func (s crashState) clone() crashState {
+	c := make(crashState, len(s))
+	for name, items := range s {
+		c[name] = make(map[string]string, len(items))
+		for key, value := range items {
+			c[name][key] = value
+		}
+	}
+	return c
+}
+
+func readCrashState(t *testing.T, db *DB) crashState {
+	tx := db.ReadTx()
+	defer tx.Rollback()
+
+	collections, err := tx.Collections()
+	require.NoError(t, err)
+
+	state := crashState{}
+	for _, collection := range collections {
+		items := map[string]string{}
+		cursor := collection.Cursor()
+		key, value, err := cursor.First()
+		for ; key != nil && err == nil; key, value, err = cursor.Next() {
+			items[string(key)] = string(value)
+		}
+		require.NoError(t, err)
+		state[string(collection.name)] = items
+	}
+	return state
+}
+
+// runCrashWorkload runs random write transactions until one of them fails. The state after the last successful
+// commit and the state the failed transaction tried to commit are returned.
+func runCrashWorkload(r *rand.Rand, db *DB, state crashState) (committed crashState, attempted crashState) {
+	for {
+		attempted = state.clone()
+		tx := db.WriteTx()
+		err := applyCrashTx(r, tx, attempted)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			return state, attempted
+		}
+		state = attempted
+	}
+}
+
+func applyCrashTx(r *rand.Rand, tx *tx, state crashState) error {
+	for i := 0; i < 1+r.Intn(30); i++ {
+		name := fmt.Sprintf("collection%d", r.Intn(3))
+		collection, err := tx.GetCollection([]byte(name))
+		if err != nil {
+			return err
+		}
+		if collection == nil {
+			collection, err = tx.CreateCollection([]byte(name))
+			if err != nil {
+				return err
+			}
+			state[name] = map[string]string{}
+		}
+
+		key := fmt.Sprintf("key%04d", r.Intn(400))
+		switch op := r.Intn(60); {
+		case op == 0:
+			err = tx.DeleteCollection([]byte(name))
+			delete(state, name)
+		case op < 3:
+			end := fmt.Sprintf("key%04d", r.Intn(400))
+			err = collection.DeleteRange([]byte(key), []byte(end))
+			for k := range state[name] {
+				if k >= key && k < end {
+					delete(state[name], k)
+				}
+			}
+		case op < 20:
+			err = collection.Remove([]byte(key))
+			delete(state[name], key)
+		default:
+			value := fmt.Sprintf("value%d-%d", r.Int(), r.Intn(1000))
+			err = collection.Put([]byte(key), []byte(value))
+			state[name][key] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDB_CrashConsistency(t *testing.T) {
+	for seed := int64(0); seed < 30; seed++ {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			r := rand.New(rand.NewSource(seed))
+			storage := newFaultStorage()
+			options := &Options{MinFillPercent: 0.2, MaxFillPercent: 0.5}
+
+			db, err := OpenStorage(storage, options)
+			require.NoError(t, err)
+
+			state := crashState{}
+			for round := 0; round < 5; round++ {
+				storage.failAt = r.Intn(200)
+				storage.tear = r.Intn(2) == 0
+				committed, attempted := runCrashWorkload(r, db, state)
+
+				storage.crash(r)
+				db, err = OpenStorage(storage, options)
+				require.NoError(t, err, "round %d", round)
+
+				// The failed transaction may have reached the disk if only the final sync failed
+				state = readCrashState(t, db)
+				if !crashStatesEqual(state, attempted) {
+					require.Equal(t, committed, state, "round %d", round)
+				}
+
+				report, err := db.Check()
+				require.NoError(t, err)
+				require.True(t, report.OK(), "round %d: %v", round, report.Violations)
+			}
+			require.NoError(t, db.Close())
+		})
+	}
+}
+
+func crashStatesEqual(s1, s2 crashState) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for name, items := range s1 {
+		other, ok := s2[name]
+		if !ok || len(items) != len(other) {
+			return false
+		}
+		for key, value := range items {
+			if v, ok := other[key]; !ok || v != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestFaultStorage_CrashDropsUnsyncedWrites(t *testing.T) {
+	storage := newFaultStorage()
+	_, err := storage.WriteAt([]byte("synced"), 0)
+	require.NoError(t, err)
+	require.NoError(t, storage.Sync())
+
+	_, err = storage.WriteAt([]byte("lost"), 6)
+	require.NoError(t, err)
+
+	// A source that always drops pending operations
+	storage.crash(rand.New(&constantSource{}))
+	require.Equal(t, []byte("synced"), storage.current)
+
+	storage.failAt = 1
+	_, err = storage.WriteAt([]byte("ok"), 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, storage.Sync(), errInjectedFault)
+	_, err = storage.WriteAt([]byte("ok"), 0)
+	require.ErrorIs(t, err, errInjectedFault)
+}
+
+// constantSource makes rand.Intn always return 0.
+type constantSource struct{}
+
+func (s *constantSource) Int63() int64 { return 0 }
+func (s *constantSource) Seed(int64)   {}
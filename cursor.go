@@ -0,0 +1,192 @@
+package LibraDB
+
+import "time"
+
+// Cursor iterates over the items of a collection in key order. Items live in both leaf and internal nodes, so the
+// cursor keeps the path from the root to the current item. For the node at the top of the stack, index is the current
+// item. For the nodes below it, index is the child the cursor descended into, which is also the item that comes after
+// that child's subtree.
+type Cursor struct {
+	collection *Collection
+	stack      []cursorFrame
+	// expiryTimes is the hidden collection of the expiry times of the collection, nil if none of its keys expire.
+	// Expired items are skipped.
+	expiryTimes *Collection
+	now         time.Time
+}
+
+type cursorFrame struct {
+	node  *Node
+	index int
+}
+
+// Cursor returns a cursor over the collection. The cursor is valid only as long as the transaction is open.
+func (c *Collection) Cursor() *Cursor {
+	return &Cursor{
+		collection: c,
+	}
+}
+
+// First moves the cursor to the first item of the collection and returns it. nil is returned if the collection is
+// empty.
+func (cur *Cursor) First() (key []byte, value []byte, err error) {
+	err = cur.loadExpiryTimes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cur.skipExpired(cur.first())
+}
+
+func (cur *Cursor) first() ([]byte, []byte, error) {
+	cur.stack = cur.stack[:0]
+	root, err := cur.collection.tx.getNode(cur.collection.root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = cur.descendLeftmost(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cur.current()
+}
+
+// Seek moves the cursor to the first item whose key is equal to or bigger than the given key and returns it. nil is
+// returned if there's no such item.
+func (cur *Cursor) Seek(seek []byte) (key []byte, value []byte, err error) {
+	err = cur.loadExpiryTimes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cur.skipExpired(cur.seek(seek))
+}
+
+func (cur *Cursor) seek(seek []byte) ([]byte, []byte, error) {
+	cur.stack = cur.stack[:0]
+	node, err := cur.collection.tx.getNode(cur.collection.root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		wasFound, index := node.findKeyInNode(seek, cur.collection.compare())
+		cur.stack = append(cur.stack, cursorFrame{node, index})
+		if wasFound {
+			return cur.current()
+		}
+
+		if node.isLeaf() {
+			if index < len(node.items) {
+				return cur.current()
+			}
+			return cur.ascend()
+		}
+
+		node, err = node.getNode(node.childNodes[index])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// Next moves the cursor to the next item and returns it. nil is returned once the cursor moved past the last item.
+func (cur *Cursor) Next() (key []byte, value []byte, err error) {
+	return cur.skipExpired(cur.next())
+}
+
+func (cur *Cursor) next() ([]byte, []byte, error) {
+	if len(cur.stack) == 0 {
+		return nil, nil, nil
+	}
+	// Items of the same node don't go through getNode, so the context is checked here as well for long scans
+	err := cur.collection.tx.ctx.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	top := &cur.stack[len(cur.stack)-1]
+	if !top.node.isLeaf() {
+		// The next item is the leftmost item in the subtree right of the current item.
+		top.index++
+		child, err := top.node.getNode(top.node.childNodes[top.index])
+		if err != nil {
+			return nil, nil, err
+		}
+		err = cur.descendLeftmost(child)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cur.current()
+	}
+
+	top.index++
+	if top.index < len(top.node.items) {
+		return cur.current()
+	}
+	return cur.ascend()
+}
+
+// descendLeftmost pushes the path from the given node to its leftmost leaf.
+func (cur *Cursor) descendLeftmost(node *Node) error {
+	var err error
+	for {
+		cur.stack = append(cur.stack, cursorFrame{node, 0})
+		if node.isLeaf() {
+			return nil
+		}
+		node, err = node.getNode(node.childNodes[0])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ascend is called once the leaf at the top of the stack is exhausted. It pops nodes until it finds an ancestor that
+// still has an item after the subtree the cursor came from.
+func (cur *Cursor) ascend() ([]byte, []byte, error) {
+	cur.stack = cur.stack[:len(cur.stack)-1]
+	for len(cur.stack) > 0 {
+		top := cur.stack[len(cur.stack)-1]
+		if top.index < len(top.node.items) {
+			return cur.current()
+		}
+		cur.stack = cur.stack[:len(cur.stack)-1]
+	}
+	return nil, nil, nil
+}
+
+func (cur *Cursor) current() ([]byte, []byte, error) {
+	top := cur.stack[len(cur.stack)-1]
+	if top.index >= len(top.node.items) {
+		// Only possible for an empty root
+		cur.stack = cur.stack[:0]
+		return nil, nil, nil
+	}
+	item := top.node.items[top.index]
+	return item.key, item.value, nil
+}
+
+// loadExpiryTimes looks up the expiry times of the collection when the cursor is positioned, so the items are checked
+// against the time the scan started.
+func (cur *Cursor) loadExpiryTimes() error {
+	var err error
+	cur.expiryTimes, err = cur.collection.expiryCollection(expiryTimesKeyPrefix, false)
+	cur.now = cur.collection.tx.db.now()
+	return err
+}
+
+// skipExpired moves the cursor past expired items, starting from the given item.
+func (cur *Cursor) skipExpired(key []byte, value []byte, err error) ([]byte, []byte, error) {
+	for cur.expiryTimes != nil && key != nil && err == nil {
+		var expired bool
+		expired, err = cur.expiryTimes.isExpiredKey(key, cur.now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !expired {
+			break
+		}
+		key, value, err = cur.next()
+	}
+	return key, value, err
+}
@@ -0,0 +1,108 @@
+package LibraDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCursor_Iterate(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	tx := collection.tx.db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	expected := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	actual := make([]string, 0)
+	cursor := collection.Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil; key, value, err = cursor.Next() {
+		require.NoError(t, err)
+		assert.Equal(t, key, value)
+		actual = append(actual, string(key[0]))
+	}
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// Calling Next after the end keeps returning nil
+	key, _, err = cursor.Next()
+	require.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestCursor_Seek(t *testing.T) {
+	collection, cleanFunc := createTestMockTree(t)
+	defer cleanFunc()
+
+	tx := collection.tx.db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	cursor := collection.Cursor()
+
+	// Exact match in an internal node
+	key, _, err := cursor.Seek(createItem("5"))
+	require.NoError(t, err)
+	assert.Equal(t, createItem("5"), key)
+	key, _, err = cursor.Next()
+	require.NoError(t, err)
+	assert.Equal(t, createItem("6"), key)
+
+	// Between keys of a leaf
+	key, _, err = cursor.Seek([]byte("7"))
+	require.NoError(t, err)
+	assert.Equal(t, createItem("7"), key)
+
+	// After the last key of a leaf, so the cursor moves to the parent
+	key, _, err = cursor.Seek(append(createItem("1"), '1'))
+	require.NoError(t, err)
+	assert.Equal(t, createItem("2"), key)
+
+	// After the last key of the collection
+	key, _, err = cursor.Seek([]byte("a"))
+	require.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestCursor_EmptyCollection(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	cursor := collection.Cursor()
+	key, _, err := cursor.First()
+	require.NoError(t, err)
+	assert.Nil(t, key)
+
+	key, _, err = cursor.Seek([]byte("0"))
+	require.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestTx_Collections(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection([]byte("b"))
+	require.NoError(t, err)
+	_, err = tx.CreateCollection([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collections, err := tx.Collections()
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
+	assert.Equal(t, []byte("a"), collections[0].Name())
+	assert.Equal(t, []byte("b"), collections[1].Name())
+}
@@ -1,9 +1,9 @@
 package LibraDB
 
 import (
-	"errors"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 )
 
 type pgnum uint64
@@ -13,6 +13,31 @@ type Options struct {
 
 	MinFillPercent float32
 	MaxFillPercent float32
+
+	// InMemory keeps the database in memory instead of a file. The path is ignored and the data is lost on close.
+	InMemory bool
+
+	// TxLeakThreshold turns on a debug mode that reports every transaction still open after the threshold, along with
+	// the stack that opened it. It's off when 0.
+	TxLeakThreshold time.Duration
+	// TxLeakHandler is called with the reports of the debug mode. It defaults to logging them.
+	TxLeakHandler func(leak *TxLeak)
+
+	// MergeOperators holds the merge operator of each collection by its name, used by Collection.Merge.
+	MergeOperators map[string]MergeOperator
+
+	// Comparators holds the comparators collections can be created with by their name. A collection created with a
+	// comparator can be opened only if it's registered.
+	Comparators map[string]Comparator
+
+	// Indexes holds the secondary indexes of the collections, kept up to date by every write.
+	Indexes []*Index
+
+	// ExpiryInterval is how often items whose ttl passed are removed in the background. They are removed only by
+	// DB.RemoveExpired when it's 0.
+	ExpiryInterval time.Duration
+	// ExpiryBatchSize is the most expired items removed by a single write transaction. It defaults to 100.
+	ExpiryBatchSize int
 }
 
 var DefaultOptions = &Options{
@@ -29,66 +54,85 @@ type dal struct {
 	pageSize       int
 	minFillPercent float32
 	maxFillPercent float32
-	file           *os.File
+	storage        Storage
+
+	// pinMu guards pins and reusable. The database is pinned while backups copy the pages of a committed state, and
+	// pages released since aren't reused until they end, so the pages they copy aren't overwritten. Only the first
+	// reusable released pages were already free when the database was pinned.
+	pinMu    sync.Mutex
+	pins     int
+	reusable int
 
 	*meta
 	*freelist
 }
 
-func newDal(path string, options *Options) (*dal, error) {
+func newDal(storage Storage, options *Options) (*dal, error) {
 	dal := &dal{
 		meta:           newEmptyMeta(),
 		pageSize:       options.pageSize,
 		minFillPercent: options.MinFillPercent,
 		maxFillPercent: options.MaxFillPercent,
+		storage:        storage,
 	}
 
-	// exist
-	if _, err := os.Stat(path); err == nil {
-		dal.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
-		if err != nil {
-			_ = dal.close()
-			return nil, err
-		}
+	// The storage isn't closed if it can't be locked, since closing it releases the lock held by its owner.
+	err := storage.Lock()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := storage.Size()
+	if err != nil {
+		_ = dal.close()
+		return nil, err
+	}
 
+	// exist
+	if size > 0 {
 		meta, err := dal.readMeta()
 		if err != nil {
+			_ = dal.close()
 			return nil, err
 		}
 		dal.meta = meta
 
 		freelist, err := dal.readFreelist()
 		if err != nil {
+			_ = dal.close()
 			return nil, err
 		}
 		dal.freelist = freelist
 		// doesn't exist
-	} else if errors.Is(err, os.ErrNotExist) {
+	} else {
 		// init freelist
-		dal.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		dal.freelist = newFreelist()
+		dal.freelistPage = dal.getNextPage()
+
+		// init root
+		collectionsNode, err := dal.writeNode(NewNodeForSerialization([]*Item{}, []pgnum{}))
 		if err != nil {
 			_ = dal.close()
 			return nil, err
 		}
+		dal.root = collectionsNode.pageNum
 
-		dal.freelist = newFreelist()
-		dal.freelistPage = dal.getNextPage()
-		_, err := dal.writeFreelist()
+		// The freelist is written after the root page is allocated, so it's counted in maxPage.
+		err = dal.writeFreelist()
 		if err != nil {
+			_ = dal.close()
 			return nil, err
 		}
 
-		// init root
-		collectionsNode, err := dal.writeNode(NewNodeForSerialization([]*Item{}, []pgnum{}))
+		// write meta page
+		_, err = dal.writeMeta(dal.meta)
+		if err == nil {
+			err = dal.sync()
+		}
 		if err != nil {
+			_ = dal.close()
 			return nil, err
 		}
-		dal.root = collectionsNode.pageNum
-
-		// write meta page
-		_, err = dal.writeMeta(dal.meta) // other error
-	} else {
-		return nil, err
 	}
 	return dal, nil
 }
@@ -112,6 +156,48 @@ func (d *dal) getSplitIndex(node *Node) int {
 	return -1
 }
 
+// getNextPage returns a page for writing, the same as the freelist. While the database is pinned, only the pages that
+// were free when it was pinned are reused.
+func (d *dal) getNextPage() pgnum {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	if d.pins == 0 {
+		return d.freelist.getNextPage()
+	}
+	if d.reusable == 0 {
+		d.maxPage += 1
+		return d.maxPage
+	}
+
+	d.reusable--
+	pageNum := d.releasedPages[d.reusable]
+	d.releasedPages = append(d.releasedPages[:d.reusable], d.releasedPages[d.reusable+1:]...)
+	return pageNum
+}
+
+// pin keeps the pages of the last committed state from being reused until unpin is called. It must be called inside a
+// transaction, so no commit happens between finding the pages and pinning them.
+func (d *dal) pin() {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	if d.pins == 0 {
+		d.reusable = len(d.releasedPages)
+	}
+	d.pins++
+}
+
+func (d *dal) unpin() {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	d.pins--
+}
+
+func (d *dal) isPinned() bool {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+	return d.pins > 0
+}
+
 func (d *dal) maxThreshold() float32 {
 	return d.maxFillPercent * float32(d.pageSize)
 }
@@ -129,12 +215,12 @@ func (d *dal) isUnderPopulated(node *Node) bool {
 }
 
 func (d *dal) close() error {
-	if d.file != nil {
-		err := d.file.Close()
+	if d.storage != nil {
+		err := d.storage.Close()
 		if err != nil {
-			return fmt.Errorf("could not close file: %s", err)
+			return fmt.Errorf("could not close storage: %s", err)
 		}
-		d.file = nil
+		d.storage = nil
 	}
 
 	return nil
@@ -150,7 +236,7 @@ func (d *dal) readPage(pageNum pgnum) (*page, error) {
 	p := d.allocateEmptyPage()
 
 	offset := int(pageNum) * d.pageSize
-	_, err := d.file.ReadAt(p.data, int64(offset))
+	_, err := d.storage.ReadAt(p.data, int64(offset))
 	if err != nil {
 		return nil, err
 	}
@@ -159,17 +245,112 @@ func (d *dal) readPage(pageNum pgnum) (*page, error) {
 
 func (d *dal) writePage(p *page) error {
 	offset := int64(p.num) * int64(d.pageSize)
-	_, err := d.file.WriteAt(p.data, offset)
+	_, err := d.storage.WriteAt(p.data, offset)
 	return err
 }
 
+// size returns the size of the database file in bytes.
+func (d *dal) size() (int64, error) {
+	return d.storage.Size()
+}
+
+// truncate cuts the database file to the given size and flushes it to the disk.
+func (d *dal) truncate(size int64) error {
+	err := d.storage.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return d.sync()
+}
+
+// sync makes everything written so far durable.
+func (d *dal) sync() error {
+	return d.storage.Sync()
+}
+
+type pageKind int
+
+const (
+	freelistPageKind pageKind = iota
+	rootCollectionPageKind
+	collectionPageKind
+)
+
+// livePages returns all the pages reachable from the given meta page (excluding the meta page itself) and what each
+// of them holds. Every page that isn't returned is free to be reused.
+func (d *dal) livePages(m *meta) (map[pgnum]pageKind, error) {
+	freelist, err := d.readFreelistAt(m.freelistPage)
+	if err != nil {
+		return nil, err
+	}
+	pages := map[pgnum]pageKind{m.freelistPage: freelistPageKind}
+	for _, pageNum := range freelist.overflowPages {
+		pages[pageNum] = freelistPageKind
+	}
+
+	var collectionRoots []pgnum
+	err = d.walkNodes(m.root, func(node *Node) error {
+		pages[node.pageNum] = rootCollectionPageKind
+		for _, item := range node.items {
+			if !isCollectionKey(item.key) {
+				continue
+			}
+			collection := newEmptyCollection()
+			collection.deserialize(item)
+			collectionRoots = append(collectionRoots, collection.root)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, collectionRoot := range collectionRoots {
+		if collectionRoot == 0 {
+			continue
+		}
+		err = d.walkNodes(collectionRoot, func(node *Node) error {
+			pages[node.pageNum] = collectionPageKind
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// walkNodes calls fn for every node of the tree rooted at the given page, in pre order.
+func (d *dal) walkNodes(pageNum pgnum, fn func(node *Node) error) error {
+	node, err := d.getNode(pageNum)
+	if err != nil {
+		return err
+	}
+
+	err = fn(node)
+	if err != nil {
+		return err
+	}
+
+	for _, childNode := range node.childNodes {
+		err = d.walkNodes(childNode, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *dal) getNode(pageNum pgnum) (*Node, error) {
 	p, err := d.readPage(pageNum)
 	if err != nil {
 		return nil, err
 	}
 	node := NewEmptyNode()
-	node.deserialize(p.data)
+	err = node.deserialize(p.data)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", pageNum, err)
+	}
 	node.pageNum = pageNum
 	return node, nil
 }
@@ -197,48 +378,148 @@ func (d *dal) deleteNode(pageNum pgnum) {
 }
 
 func (d *dal) readFreelist() (*freelist, error) {
-	p, err := d.readPage(d.freelistPage)
-	if err != nil {
-		return nil, err
-	}
+	return d.readFreelistAt(d.freelistPage)
+}
 
+// readFreelistAt reads the freelist starting at the given page, following its overflow pages.
+func (d *dal) readFreelistAt(pageNum pgnum) (*freelist, error) {
 	freelist := newFreelist()
-	freelist.deserialize(p.data)
-	return freelist, nil
+	for current := pageNum; ; {
+		p, err := d.readPage(current)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := freelist.deserializePage(p.data)
+		if err != nil {
+			return nil, fmt.Errorf("freelist page %d: %w", current, err)
+		}
+		if next == 0 {
+			return freelist, nil
+		}
+		// The pages of the freelist are distinct, so a longer chain loops
+		if next > freelist.maxPage || len(freelist.overflowPages) >= int(freelist.maxPage) {
+			return nil, fmt.Errorf("freelist page %d: %w", current, corruptedPageErr)
+		}
+		freelist.overflowPages = append(freelist.overflowPages, next)
+		current = next
+	}
 }
 
-func (d *dal) writeFreelist() (*page, error) {
-	p := d.allocateEmptyPage()
-	p.num = d.freelistPage
-	d.freelist.serialize(p.data)
+// freelistPages returns the pages the freelist is written to.
+func (d *dal) freelistPages() []pgnum {
+	return append([]pgnum{d.freelistPage}, d.overflowPages...)
+}
 
-	err := d.writePage(p)
-	if err != nil {
-		return nil, err
+// allocateFreelist allocates the pages the next freelist is written to, so it holds the pending pages as well once they
+// are released. The pages are allocated before the pending pages are released, as those may still be used by the last
+// committed state.
+func (d *dal) allocateFreelist(pending int) {
+	d.freelistPage = d.getNextPage()
+	d.overflowPages = nil
+	// Every page allocated from the released pages makes the freelist shorter
+	for (len(d.overflowPages)+1)*pageCapacity(d.pageSize) < len(d.releasedPages)+pending {
+		d.overflowPages = append(d.overflowPages, d.getNextPage())
 	}
-	d.freelistPage = p.num
-	return p, nil
 }
 
+// writeFreelist writes the released pages to the pages of the freelist, each pointing to the next one.
+func (d *dal) writeFreelist() error {
+	pages := d.freelistPages()
+	capacity := pageCapacity(d.pageSize)
+	if len(d.releasedPages) > len(pages)*capacity {
+		return freelistTooBigErr
+	}
+
+	releasedPages := d.releasedPages
+	for i, pageNum := range pages {
+		n := capacity
+		if n > len(releasedPages) {
+			n = len(releasedPages)
+		}
+		var next pgnum
+		if i < len(pages)-1 {
+			next = pages[i+1]
+		}
+
+		p := d.allocateEmptyPage()
+		p.num = pageNum
+		d.freelist.serializePage(p.data, releasedPages[:n], next)
+		releasedPages = releasedPages[n:]
+
+		err := d.writePage(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMeta writes the meta to its slot in the meta page. Only the slot itself is written, so a failure can't damage
+// the other slot, which holds the previous commit.
 func (d *dal) writeMeta(meta *meta) (*page, error) {
 	p := d.allocateEmptyPage()
 	p.num = metaPageNum
 	meta.serialize(p.data)
 
-	err := d.writePage(p)
+	offset := int64(p.num)*int64(d.pageSize) + d.metaSlotOffset(meta.txid)
+	_, err := d.storage.WriteAt(p.data[:metaSize], offset)
 	if err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
+// readMeta returns the meta from the valid slot with the highest txid.
 func (d *dal) readMeta() (*meta, error) {
 	p, err := d.readPage(metaPageNum)
 	if err != nil {
 		return nil, err
 	}
 
-	meta := newEmptyMeta()
-	meta.deserialize(p.data)
-	return meta, nil
+	var latest *meta
+	for slot := uint64(0); slot < 2; slot++ {
+		buf := p.data[d.metaSlotOffset(slot):]
+		if !isValidMeta(buf) {
+			continue
+		}
+
+		meta := newEmptyMeta()
+		if meta.deserialize(buf) != nil {
+			continue
+		}
+		if latest == nil || meta.txid > latest.txid {
+			latest = meta
+		}
+	}
+
+	if latest == nil {
+		return nil, invalidMetaErr
+	}
+	return latest, nil
+}
+
+func (d *dal) metaSlotOffset(txid uint64) int64 {
+	return int64(txid%2) * int64(d.pageSize/2)
+}
+
+// reload reads the meta and the freelist again from the storage, dropping any change made to them in memory.
+func (d *dal) reload() error {
+	meta, err := d.readMeta()
+	if err != nil {
+		return err
+	}
+	d.meta = meta
+
+	freelist, err := d.readFreelist()
+	if err != nil {
+		return err
+	}
+	d.freelist = freelist
+
+	// The order of the released pages changed, so none of them is known to be free when the database was pinned
+	d.pinMu.Lock()
+	d.reusable = 0
+	d.pinMu.Unlock()
+	return nil
 }
@@ -9,7 +9,9 @@ import (
 
 func createTestDAL(t *testing.T) (*dal, func()) {
 	fileName := getTempFileName()
-	dal, err := newDal(fileName, &Options{
+	storage, err := newFileStorage(fileName)
+	require.NoError(t, err)
+	dal, err := newDal(storage, &Options{
 		pageSize: testPageSize,
 	})
 	require.NoError(t, err)
@@ -1,18 +1,66 @@
 package LibraDB
 
 import (
+	"context"
 	"os"
 	"sync"
+	"time"
 )
 
 type DB struct {
 	rwlock   sync.RWMutex   // Allows only one writer at a time
 	*dal
+
+	txLeakThreshold time.Duration
+	txLeakHandler   func(leak *TxLeak)
+
+	watchMu  sync.Mutex
+	watchers map[*Watcher]struct{}
+
+	mergeOperators map[string]MergeOperator
+	comparators    map[string]Comparator
+	// indexes holds the indexes of each collection by its name.
+	indexes map[string][]*Index
+
+	// now returns the time ttls are checked against.
+	now             func() time.Time
+	expiryBatchSize int
+	stopReaper      chan struct{}
+	reaperDone      chan struct{}
 }
 
+// MemoryPath can be passed to Open instead of a path to keep the database in memory, same as setting
+// Options.InMemory.
+const MemoryPath = ":memory:"
+
 func Open(path string, options *Options) (*DB, error) {
+	if path == MemoryPath || options.InMemory {
+		return OpenStorage(NewMemoryStorage(), options)
+	}
+
+	storage, err := newFileStorage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenStorage(storage, options)
+	if err != nil {
+		_ = storage.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenStorage opens a database kept in the given storage. An empty storage is initialized as a new database. The
+// storage is closed when the database is closed, or if opening fails after the storage was locked.
+func OpenStorage(storage Storage, options *Options) (*DB, error) {
+	err := checkIndexes(options.Indexes)
+	if err != nil {
+		return nil, err
+	}
+
 	options.pageSize = os.Getpagesize()
-	dal, err := newDal(path, options)
+	dal, err := newDal(storage, options)
 	if err != nil {
 		return nil, err
 	}
@@ -20,21 +68,130 @@ func Open(path string, options *Options) (*DB, error) {
 	db := &DB{
 		sync.RWMutex{},
 		dal,
+		options.TxLeakThreshold,
+		options.TxLeakHandler,
+		sync.Mutex{},
+		map[*Watcher]struct{}{},
+		map[string]MergeOperator{},
+		map[string]Comparator{},
+		map[string][]*Index{},
+		time.Now,
+		options.ExpiryBatchSize,
+		nil,
+		nil,
+	}
+	if db.txLeakHandler == nil {
+		db.txLeakHandler = logTxLeak
+	}
+	for name, operator := range options.MergeOperators {
+		db.mergeOperators[name] = operator
+	}
+	for name, comparator := range options.Comparators {
+		db.comparators[name] = comparator
+	}
+	for _, index := range options.Indexes {
+		db.indexes[string(index.Collection)] = append(db.indexes[string(index.Collection)], index)
+	}
+	if db.expiryBatchSize <= 0 {
+		db.expiryBatchSize = defaultExpiryBatchSize
+	}
+	if options.ExpiryInterval > 0 {
+		db.stopReaper = make(chan struct{})
+		db.reaperDone = make(chan struct{})
+		go db.runReaper(options.ExpiryInterval)
 	}
 
 	return db, nil
 }
 
 func (db *DB) Close() error {
+	db.stopReaping()
+	db.closeWatchers()
 	return db.close()
 }
 
 func (db *DB) ReadTx() *tx {
 	db.rwlock.RLock()
-	return newTx(db, false)
+	return newTx(context.Background(), db, false)
 }
 
 func (db *DB) WriteTx() *tx {
 	db.rwlock.Lock()
-	return newTx(db, true)
-}
\ No newline at end of file
+	return newTx(context.Background(), db, true)
+}
+
+// TxOptions holds the options of a transaction opened with BeginTx.
+type TxOptions struct {
+	// ReadOnly opens a read transaction instead of a write transaction.
+	ReadOnly bool
+}
+
+// BeginTx opens a transaction bound to the given context. If the database lock can't be acquired before the context
+// is done, the context's error is returned. Once the transaction is open, reads, scans and the commit return the
+// context's error after it's done. A nil opts opens a write transaction.
+func (db *DB) BeginTx(ctx context.Context, opts *TxOptions) (*tx, error) {
+	write := opts == nil || !opts.ReadOnly
+
+	var err error
+	if write {
+		err = lockContext(ctx, db.rwlock.Lock, db.rwlock.Unlock)
+	} else {
+		err = lockContext(ctx, db.rwlock.RLock, db.rwlock.RUnlock)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTx(ctx, db, write), nil
+}
+
+// lockContext acquires the lock, or gives up once the context is done. A lock that is acquired after giving up is
+// released right away.
+func lockContext(ctx context.Context, lock func(), unlock func()) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// Stats describes the layout of the database file.
+type Stats struct {
+	PageSize     int
+	PageCount    int
+	FreePages    int
+	RootPage     uint64
+	FreelistPage uint64
+}
+
+func (db *DB) Stats() Stats {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	return Stats{
+		PageSize:     db.pageSize,
+		PageCount:    int(db.maxPage) + 1,
+		FreePages:    len(db.releasedPages),
+		RootPage:     uint64(db.root),
+		FreelistPage: uint64(db.freelistPage),
+	}
+}
@@ -3,6 +3,7 @@ package LibraDB
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
 	"testing"
 )
 
@@ -139,3 +140,60 @@ func TestDB_DeleteItem(t *testing.T) {
 	err = tx.Commit()
 	require.NoError(t, err)
 }
+
+func TestDB_Stats(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	stats := db.Stats()
+	assert.Equal(t, Stats{
+		PageSize:     db.pageSize,
+		PageCount:    3,
+		FreePages:    0,
+		RootPage:     2,
+		FreelistPage: 1,
+	}, stats)
+}
+
+func TestDB_OpenInMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		path    string
+		options *Options
+	}{
+		{"MemoryPath", MemoryPath, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage}},
+		{"InMemoryOption", getTempFileName(), &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage, InMemory: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := Open(tc.path, tc.options)
+			require.NoError(t, err)
+			defer db.Close()
+
+			_, isMemory := db.storage.(*MemoryStorage)
+			assert.True(t, isMemory)
+			_, err = os.Stat(tc.path)
+			assert.True(t, os.IsNotExist(err))
+
+			tx := db.WriteTx()
+			collection, err := tx.CreateCollection(testCollectionName)
+			require.NoError(t, err)
+			for _, key := range []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"} {
+				val := createItem(key)
+				require.NoError(t, collection.Put(val, val))
+			}
+			require.NoError(t, tx.Commit())
+
+			tx = db.ReadTx()
+			collection, err = tx.GetCollection(testCollectionName)
+			require.NoError(t, err)
+			item, err := collection.Find(createItem("7"))
+			require.NoError(t, err)
+			assert.Equal(t, createItem("7"), item.value)
+			require.NoError(t, tx.Commit())
+
+			report, err := db.Check()
+			require.NoError(t, err)
+			assert.True(t, report.OK())
+		})
+	}
+}
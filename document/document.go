@@ -0,0 +1,418 @@
+// Package document stores JSON documents in a LibraDB collection. Documents are JSON objects identified by ids taken
+// from the sequence of the collection, and they can be queried by filters on their fields. Filters use a secondary
+// index of the field when one is registered with Index.
+package document
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amit-davidson/LibraDB"
+	"github.com/amit-davidson/LibraDB/tuple"
+)
+
+var (
+	ErrNotFound  = errors.New("the document doesn't exist")
+	ErrNotObject = errors.New("a document must be a JSON object")
+)
+
+// Document is a JSON object stored under an id.
+type Document struct {
+	ID     uint64
+	Fields map[string]interface{}
+}
+
+// Get returns the value at a path of dot separated field names, such as "address.city". A name is used as an index
+// into arrays, so "tags.0" is the first tag. false is returned if there's no value at the path.
+func (d *Document) Get(path string) (interface{}, bool) {
+	return lookup(d.Fields, path)
+}
+
+// Decode decodes the fields of the document into v, the same as json.Unmarshal.
+func (d *Document) Decode(v interface{}) error {
+	b, err := json.Marshal(d.Fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Collection stores documents in a collection. It's valid only as long as the transaction of the collection is open.
+type Collection struct {
+	collection *LibraDB.Collection
+}
+
+// New returns a document collection stored in the given collection.
+func New(collection *LibraDB.Collection) *Collection {
+	return &Collection{collection: collection}
+}
+
+func encodeID(id uint64) []byte {
+	key, _ := LibraDB.Uint64Codec.Encode(id)
+	return key
+}
+
+// toObject converts a value that is marshaled to a JSON object to the fields of a document.
+func toObject(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(b, &fields)
+	if err != nil || fields == nil {
+		return nil, ErrNotObject
+	}
+	return fields, nil
+}
+
+func decode(key []byte, value []byte) (*Document, error) {
+	id, err := LibraDB.Uint64Codec.Decode(key)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{ID: id}
+	err = json.Unmarshal(value, &doc.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Insert stores v as a new document and returns its id. v must be marshaled to a JSON object that fits in
+// LibraDB.MaxValueSize.
+func (c *Collection) Insert(v interface{}) (uint64, error) {
+	fields, err := toObject(v)
+	if err != nil {
+		return 0, err
+	}
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := c.collection.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	err = c.collection.Put(encodeID(id), value)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Get returns the document with the given id, or nil if it doesn't exist.
+func (c *Collection) Get(id uint64) (*Document, error) {
+	item, err := c.collection.Find(encodeID(id))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return decode(item.Key(), item.Value())
+}
+
+// Update applies a JSON merge patch (RFC 7386) to the document with the given id. Fields of the patch replace the
+// fields of the document, objects are patched recursively, and null fields are removed. ErrNotFound is returned if the
+// document doesn't exist.
+func (c *Collection) Update(id uint64, patch interface{}) error {
+	doc, err := c.Get(id)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return ErrNotFound
+	}
+	patchFields, err := toObject(patch)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(mergePatch(doc.Fields, patchFields))
+	if err != nil {
+		return err
+	}
+	return c.collection.Put(encodeID(id), value)
+}
+
+// mergePatch applies the patch to the fields and returns them.
+func mergePatch(fields map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	for name, patchValue := range patch {
+		if patchValue == nil {
+			delete(fields, name)
+			continue
+		}
+		patchObject, ok := patchValue.(map[string]interface{})
+		if !ok {
+			fields[name] = patchValue
+			continue
+		}
+		object, ok := fields[name].(map[string]interface{})
+		if !ok {
+			object = map[string]interface{}{}
+		}
+		fields[name] = mergePatch(object, patchObject)
+	}
+	return fields
+}
+
+// Delete removes the document with the given id.
+func (c *Collection) Delete(id uint64) error {
+	return c.collection.Remove(encodeID(id))
+}
+
+// Find returns the documents that match all the filters, ordered by id. The first filter on a field that has an index
+// is looked up in the index, and the collection is scanned otherwise.
+func (c *Collection) Find(filters ...Filter) ([]*Document, error) {
+	candidates, err := c.candidates(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*Document
+	for _, doc := range candidates {
+		if matchesAll(doc, filters) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].ID < docs[j].ID
+	})
+	return docs, nil
+}
+
+// candidates returns the documents that may match the filters.
+func (c *Collection) candidates(filters []Filter) ([]*Document, error) {
+	var docs []*Document
+	for _, filter := range filters {
+		items, ok, err := filter.lookup(c.collection)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			doc, err := decode(item.Key(), item.Value())
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+		return docs, nil
+	}
+
+	cursor := c.collection.Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil; key, value, err = cursor.Next() {
+		doc, err := decode(key, value)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, err
+}
+
+func matchesAll(doc *Document, filters []Filter) bool {
+	for _, filter := range filters {
+		if !filter.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// Index returns a secondary index of the field at the path of the documents in the collection, to register in
+// LibraDB.Options.Indexes. The index is named after the path. Documents are indexed by strings, numbers, booleans and
+// nulls, and aren't indexed by other values.
+func Index(collection []byte, path string) *LibraDB.Index {
+	return &LibraDB.Index{
+		Name:       path,
+		Collection: collection,
+		Extract: func(key []byte, value []byte) [][]byte {
+			var fields map[string]interface{}
+			if json.Unmarshal(value, &fields) != nil {
+				return nil
+			}
+			v, ok := lookup(fields, path)
+			if !ok {
+				return nil
+			}
+			indexKey, ok := indexKeyOf(v)
+			if !ok {
+				return nil
+			}
+			return [][]byte{indexKey}
+		},
+	}
+}
+
+// indexKeyOf returns the index key of a JSON value. Values of the same type are ordered the same as they are compared
+// by filters.
+func indexKeyOf(v interface{}) ([]byte, bool) {
+	switch v.(type) {
+	case nil, string, float64, bool:
+		indexKey, err := tuple.Tuple{v}.Pack()
+		return indexKey, err == nil
+	default:
+		return nil, false
+	}
+}
+
+// lookup returns the value at the path in the fields.
+func lookup(fields map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = fields
+	for _, name := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[name]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+type operator int
+
+const (
+	eq operator = iota
+	gt
+	gte
+	lt
+	lte
+)
+
+// Filter matches documents by the value at a path.
+type Filter struct {
+	path     string
+	operator operator
+	value    interface{}
+}
+
+func newFilter(path string, operator operator, value interface{}) Filter {
+	// The value is converted the same as the documents' values, so numbers are compared as float64
+	b, err := json.Marshal(value)
+	if err == nil {
+		_ = json.Unmarshal(b, &value)
+	}
+	return Filter{path: path, operator: operator, value: value}
+}
+
+// Eq matches documents whose value at the path is equal to value.
+func Eq(path string, value interface{}) Filter {
+	return newFilter(path, eq, value)
+}
+
+// Gt matches documents whose value at the path is greater than value. Only numbers are compared to numbers, and
+// strings to strings.
+func Gt(path string, value interface{}) Filter {
+	return newFilter(path, gt, value)
+}
+
+// Gte matches documents whose value at the path is greater than or equal to value.
+func Gte(path string, value interface{}) Filter {
+	return newFilter(path, gte, value)
+}
+
+// Lt matches documents whose value at the path is less than value.
+func Lt(path string, value interface{}) Filter {
+	return newFilter(path, lt, value)
+}
+
+// Lte matches documents whose value at the path is less than or equal to value.
+func Lte(path string, value interface{}) Filter {
+	return newFilter(path, lte, value)
+}
+
+func (f Filter) matches(doc *Document) bool {
+	v, ok := doc.Get(f.path)
+	if !ok {
+		return false
+	}
+	if f.operator == eq {
+		return reflect.DeepEqual(v, f.value)
+	}
+
+	cmp, ok := compare(v, f.value)
+	if !ok {
+		return false
+	}
+	switch f.operator {
+	case gt:
+		return cmp > 0
+	case gte:
+		return cmp >= 0
+	case lt:
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+// compare compares two numbers or two strings. false is returned for other values.
+func compare(a interface{}, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	default:
+		return 0, false
+	}
+}
+
+// lookup returns the items whose value at the path of the filter is in the range of the filter, from the index of the
+// path. The range may include items that don't match the filter, such as values of other types. false is returned if
+// the index can't be used.
+func (f Filter) lookup(collection *LibraDB.Collection) ([]*LibraDB.Item, bool, error) {
+	indexKey, ok := indexKeyOf(f.value)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var items []*LibraDB.Item
+	var err error
+	switch f.operator {
+	case eq:
+		items, err = collection.Lookup(f.path, indexKey)
+	case gt, gte:
+		items, err = collection.LookupRange(f.path, indexKey, nil)
+	default:
+		// The smallest key that comes after the index key
+		items, err = collection.LookupRange(f.path, nil, append(indexKey, 0))
+	}
+	if errors.Is(err, LibraDB.ErrUnknownIndex) {
+		return nil, false, nil
+	}
+	return items, err == nil, err
+}
@@ -0,0 +1,181 @@
+package document
+
+import (
+	"fmt"
+	"github.com/amit-davidson/LibraDB"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var usersCollection = []byte("users")
+
+type user struct {
+	Name    string   `json:"name"`
+	Age     int      `json:"age"`
+	City    string   `json:"city,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Address *address `json:"address,omitempty"`
+}
+
+type address struct {
+	Street string `json:"street"`
+	Zip    string `json:"zip"`
+}
+
+func openTestDB(t *testing.T, indexes ...*LibraDB.Index) *LibraDB.DB {
+	db, err := LibraDB.OpenStorage(LibraDB.NewMemoryStorage(), &LibraDB.Options{
+		MinFillPercent: 0.2,
+		MaxFillPercent: 0.55,
+		Indexes:        indexes,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func ids(docs []*Document) []uint64 {
+	var ids []uint64
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+func TestCollection_CRUD(t *testing.T) {
+	db := openTestDB(t)
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(usersCollection)
+	require.NoError(t, err)
+	users := New(collection)
+
+	id, err := users.Insert(user{Name: "alice", Age: 30, Address: &address{Street: "main", Zip: "123"}})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), id)
+	id, err = users.Insert(map[string]interface{}{"name": "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), id)
+
+	doc, err := users.Get(1)
+	require.NoError(t, err)
+	require.NotNil(t, doc)
+	assert.Equal(t, uint64(1), doc.ID)
+	zip, ok := doc.Get("address.zip")
+	assert.True(t, ok)
+	assert.Equal(t, "123", zip)
+	_, ok = doc.Get("address.city")
+	assert.False(t, ok)
+	var u user
+	require.NoError(t, doc.Decode(&u))
+	assert.Equal(t, user{Name: "alice", Age: 30, Address: &address{Street: "main", Zip: "123"}}, u)
+
+	require.NoError(t, users.Update(1, map[string]interface{}{
+		"age":     31,
+		"city":    "paris",
+		"address": map[string]interface{}{"zip": nil},
+	}))
+	doc, err = users.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "alice",
+		"age":     31.0,
+		"city":    "paris",
+		"address": map[string]interface{}{"street": "main"},
+	}, doc.Fields)
+
+	assert.ErrorIs(t, users.Update(3, map[string]interface{}{"age": 1}), ErrNotFound)
+	_, err = users.Insert([]string{"not", "an", "object"})
+	assert.ErrorIs(t, err, ErrNotObject)
+
+	require.NoError(t, users.Delete(1))
+	doc, err = users.Get(1)
+	require.NoError(t, err)
+	assert.Nil(t, doc)
+}
+
+func insertUsers(t *testing.T, users *Collection) {
+	for i := 0; i < 50; i++ {
+		_, err := users.Insert(user{
+			Name: fmt.Sprintf("user%d", i),
+			Age:  i,
+			City: []string{"paris", "london", "rome"}[i%3],
+			Tags: []string{fmt.Sprintf("tag%d", i%5)},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestCollection_Find(t *testing.T) {
+	db := openTestDB(t)
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(usersCollection)
+	require.NoError(t, err)
+	users := New(collection)
+	insertUsers(t, users)
+
+	docs, err := users.Find(Eq("city", "rome"), Gte("age", 40))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42, 45, 48}, ids(docs))
+
+	docs, err = users.Find(Eq("tags.0", "tag3"), Lt("age", 20))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 9, 14, 19}, ids(docs))
+
+	docs, err = users.Find(Gt("name", "user47"))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{6, 7, 8, 9, 10, 49, 50}, ids(docs))
+
+	// Values of other types never match comparisons
+	docs, err = users.Find(Lte("age", "10"))
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+
+	docs, err = users.Find()
+	require.NoError(t, err)
+	assert.Len(t, docs, 50)
+}
+
+func TestCollection_FindWithIndex(t *testing.T) {
+	db := openTestDB(t, Index(usersCollection, "city"), Index(usersCollection, "age"))
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(usersCollection)
+	require.NoError(t, err)
+	users := New(collection)
+	insertUsers(t, users)
+	require.NoError(t, users.Update(3, map[string]interface{}{"city": "rome"}))
+	require.NoError(t, users.Delete(6))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(usersCollection)
+	require.NoError(t, err)
+	users = New(collection)
+
+	docs, err := users.Find(Eq("city", "rome"), Lt("age", 15))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 9, 12, 15}, ids(docs))
+
+	// The index is used the same way by comparisons
+	docs, err = users.Find(Gt("age", 45))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{47, 48, 49, 50}, ids(docs))
+	docs, err = users.Find(Lte("age", 5.0), Eq("city", "paris"))
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 4}, ids(docs))
+
+	// The index holds the documents by their city
+	items, err := collection.Lookup("city", indexKey(t, "london"))
+	require.NoError(t, err)
+	assert.Len(t, items, 17)
+}
+
+func indexKey(t *testing.T, v interface{}) []byte {
+	key, ok := indexKeyOf(v)
+	require.True(t, ok)
+	return key
+}
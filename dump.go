@@ -0,0 +1,85 @@
+package LibraDB
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// DumpPage writes a hex dump of the given page to w, followed by its decoded content. The meta page and the freelist
+// pages are decoded as such, any other page is decoded as a node. For the meta page, the latest valid of its two slots is
+// decoded.
+func (db *DB) DumpPage(w io.Writer, pageNum uint64) error {
+	db.rwlock.RLock()
+	defer db.rwlock.RUnlock()
+
+	if pgnum(pageNum) > db.maxPage {
+		return fmt.Errorf("page %d is out of range [0, %d]", pageNum, db.maxPage)
+	}
+
+	p, err := db.readPage(pgnum(pageNum))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "page %d\n%s\n", pageNum, hex.Dump(p.data))
+	if err != nil {
+		return err
+	}
+
+	isFreelistPage := false
+	for _, freelistPage := range db.freelistPages() {
+		isFreelistPage = isFreelistPage || freelistPage == pgnum(pageNum)
+	}
+
+	switch {
+	case pgnum(pageNum) == metaPageNum:
+		var m *meta
+		m, err = db.readMeta()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "meta: txid=%d root=%d freelist=%d\n", m.txid, m.root, m.freelistPage)
+	case isFreelistPage:
+		freelist := newFreelist()
+		var next pgnum
+		next, err = freelist.deserializePage(p.data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "freelist: maxPage=%d releasedPages=%v next=%d\n", freelist.maxPage, freelist.releasedPages, next)
+	default:
+		node := NewEmptyNode()
+		err = node.deserialize(p.data)
+		if err != nil {
+			return err
+		}
+		err = dumpNode(w, node)
+	}
+	return err
+}
+
+func dumpNode(w io.Writer, node *Node) error {
+	_, err := fmt.Fprintf(w, "node: leaf=%t items=%d\n", node.isLeaf(), len(node.items))
+	if err != nil {
+		return err
+	}
+
+	for i, item := range node.items {
+		if !node.isLeaf() {
+			_, err = fmt.Fprintf(w, "  child %d\n", node.childNodes[i])
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(w, "  %q: %q\n", item.key, item.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !node.isLeaf() {
+		_, err = fmt.Fprintf(w, "  child %d\n", node.childNodes[len(node.childNodes)-1])
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDB_DumpPage(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, tx.Commit())
+
+	out := bytes.Buffer{}
+	require.NoError(t, db.DumpPage(&out, uint64(metaPageNum)))
+	assert.Contains(t, out.String(), fmt.Sprintf("meta: txid=1 root=%d freelist=%d", db.root, db.freelistPage))
+
+	out.Reset()
+	require.NoError(t, db.DumpPage(&out, uint64(db.freelistPage)))
+	assert.Contains(t, out.String(), fmt.Sprintf("freelist: maxPage=%d", db.maxPage))
+
+	out.Reset()
+	require.NoError(t, db.DumpPage(&out, uint64(collection.root)))
+	assert.Contains(t, out.String(), "node: leaf=true items=1")
+	assert.Contains(t, out.String(), `"key1": "value1"`)
+
+	assert.Error(t, db.DumpPage(&out, uint64(db.maxPage+1)))
+}
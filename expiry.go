@@ -0,0 +1,299 @@
+package LibraDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrInvalidTTL = errors.New("the ttl must be positive")
+
+const (
+	// expiryTimesKeyPrefix is the prefix of the hidden collections holding the time each key of a collection expires at.
+	// They are ordered by the comparator of the collection, so a key is found there the same way it's found in the
+	// collection.
+	expiryTimesKeyPrefix = "\x00expiry:"
+	// expiryIndexKeyPrefix is the prefix of the hidden collections holding the keys of a collection ordered by the time
+	// they expire at, for removing expired items.
+	expiryIndexKeyPrefix = "\x00expiry-index:"
+
+	expirySize = 8
+	// MaxTTLKeySize is the longest key that can be put with a ttl, as the expiry index holds the key with its expiry
+	// time.
+	MaxTTLKeySize = MaxKeySize - expirySize
+
+	defaultExpiryBatchSize = 100
+)
+
+// PutWithTTL puts the key the same as Put, and makes it expire after the ttl. An expired item is treated as absent,
+// and it's removed by Options.ExpiryInterval or DB.RemoveExpired. Putting the key again without a ttl, or removing it,
+// clears its expiry.
+func (c *Collection) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	if len(key) > MaxTTLKeySize {
+		return ErrKeyTooLarge
+	}
+	_, err := c.putUntil(key, c.tx.db.now().Add(ttl), func(current *Item) ([]byte, bool, error) {
+		return value, true, nil
+	})
+	return err
+}
+
+func encodeExpiry(expiresAt time.Time) []byte {
+	b := make([]byte, expirySize)
+	binary.BigEndian.PutUint64(b, uint64(expiresAt.UnixNano()))
+	return b
+}
+
+func decodeExpiry(expiry []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(expiry)))
+}
+
+// isExpiredAt returns whether an encoded expiry time is at or before now.
+func isExpiredAt(expiry []byte, now time.Time) bool {
+	return bytes.Compare(expiry, encodeExpiry(now)) <= 0
+}
+
+// expiryIndexKey returns the key of the expiry index for a key of the collection and its encoded expiry time.
+func expiryIndexKey(expiry []byte, key []byte) []byte {
+	indexKey := make([]byte, 0, len(expiry)+len(key))
+	indexKey = append(indexKey, expiry...)
+	return append(indexKey, key...)
+}
+
+// expiryCollection returns the hidden collection of the collection with the given prefix. It's created if it doesn't
+// exist and create is true. nil is returned for hidden collections, since their keys never expire.
+func (c *Collection) expiryCollection(prefix string, create bool) (*Collection, error) {
+	if !create {
+		expiring, err := c.hasExpiry()
+		if err != nil || !expiring {
+			return nil, err
+		}
+	}
+	if c.isRoot || isReservedKey(c.name) {
+		return nil, nil
+	}
+
+	name := append([]byte(prefix), c.name...)
+	collection, err := c.tx.getCollection(name)
+	if err != nil || collection != nil || !create {
+		return collection, err
+	}
+	options := &CollectionOptions{}
+	if prefix == expiryTimesKeyPrefix {
+		options.Comparator = c.comparator
+	}
+	collection, err = c.tx.createCollectionWithOptions(name, options)
+	if err != nil {
+		return nil, err
+	}
+	c.tx.expiring[string(c.name)] = true
+	return collection, nil
+}
+
+// hasExpiry returns whether the collection has keys with a ttl, which is whether its expiry times exist. It's looked
+// up once per transaction.
+func (c *Collection) hasExpiry() (bool, error) {
+	if c.isRoot || isReservedKey(c.name) {
+		return false, nil
+	}
+	if expiring, ok := c.tx.expiring[string(c.name)]; ok {
+		return expiring, nil
+	}
+
+	times, err := c.tx.getCollection(append([]byte(expiryTimesKeyPrefix), c.name...))
+	if err != nil {
+		return false, err
+	}
+	c.tx.expiring[string(c.name)] = times != nil
+	return times != nil, nil
+}
+
+// isExpired returns whether the key has a ttl that passed.
+func (c *Collection) isExpired(key []byte) (bool, error) {
+	times, err := c.expiryCollection(expiryTimesKeyPrefix, false)
+	if err != nil || times == nil {
+		return false, err
+	}
+	return times.isExpiredKey(key, c.tx.db.now())
+}
+
+// isExpiredKey returns whether the key has an expiry time at or before now in the hidden collection of expiry times.
+func (times *Collection) isExpiredKey(key []byte, now time.Time) (bool, error) {
+	item, err := times.Find(key)
+	if err != nil || item == nil {
+		return false, err
+	}
+	return isExpiredAt(item.value, now), nil
+}
+
+// expiresAt returns the time the key expires at in the hidden collection of expiry times, nil if it has no ttl.
+func (times *Collection) expiresAt(key []byte) (*time.Time, error) {
+	item, err := times.Find(key)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	expiresAt := decodeExpiry(item.value)
+	return &expiresAt, nil
+}
+
+// setExpiry sets the time the key expires at. A zero time clears its expiry.
+func (c *Collection) setExpiry(key []byte, expiresAt time.Time) error {
+	create := !expiresAt.IsZero()
+	times, err := c.expiryCollection(expiryTimesKeyPrefix, create)
+	if err != nil || times == nil {
+		return err
+	}
+	index, err := c.expiryCollection(expiryIndexKeyPrefix, create)
+	if err != nil {
+		return err
+	}
+
+	current, err := times.Find(key)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		err = index.Remove(expiryIndexKey(current.value, current.key))
+		if err != nil {
+			return err
+		}
+		if !create {
+			return times.Remove(key)
+		}
+	}
+	if !create {
+		return nil
+	}
+
+	expiry := encodeExpiry(expiresAt)
+	err = times.Put(key, expiry)
+	if err != nil {
+		return err
+	}
+	return index.Put(expiryIndexKey(expiry, key), []byte{})
+}
+
+// RemoveExpired removes the items whose ttl passed, in write transactions of up to Options.ExpiryBatchSize items each,
+// and returns how many were removed.
+func (db *DB) RemoveExpired() (int, error) {
+	removed := 0
+	for {
+		n, err := db.removeExpiredBatch()
+		removed += n
+		if err != nil || n < db.expiryBatchSize {
+			return removed, err
+		}
+	}
+}
+
+func (db *DB) removeExpiredBatch() (int, error) {
+	tx := db.WriteTx()
+	removed, err := tx.removeExpired(db.expiryBatchSize)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+// removeExpired removes up to limit expired items, and returns how many were removed.
+func (tx *tx) removeExpired(limit int) (int, error) {
+	now := tx.db.now()
+
+	// The expiry indexes of all the collections are next to each other in the root collection
+	var indexNames [][]byte
+	cursor := tx.getRootCollection().Cursor()
+	name, _, err := cursor.Seek([]byte(expiryIndexKeyPrefix))
+	for ; name != nil && bytes.HasPrefix(name, []byte(expiryIndexKeyPrefix)); name, _, err = cursor.Next() {
+		indexNames = append(indexNames, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, indexName := range indexNames {
+		collection, err := tx.getCollection(indexName[len(expiryIndexKeyPrefix):])
+		if err != nil {
+			return removed, err
+		}
+		index, err := tx.getCollection(indexName)
+		if err != nil {
+			return removed, err
+		}
+
+		var keys [][]byte
+		cursor := index.Cursor()
+		entry, _, err := cursor.First()
+		for ; entry != nil && removed+len(keys) < limit && isExpiredAt(entry[:expirySize], now); entry, _, err = cursor.Next() {
+			keys = append(keys, entry[expirySize:])
+		}
+		if err != nil {
+			return removed, err
+		}
+
+		for _, key := range keys {
+			_, err = collection.remove(key, nil, true)
+			if err != nil {
+				return removed, err
+			}
+			// In case the item itself was already gone
+			err = collection.setExpiry(key, time.Time{})
+			if err != nil {
+				return removed, err
+			}
+		}
+		removed += len(keys)
+		if removed >= limit {
+			break
+		}
+	}
+	return removed, nil
+}
+
+// runReaper removes expired items every interval until the database is closed.
+func (db *DB) runReaper(interval time.Duration) {
+	defer close(db.reaperDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopReaper:
+			return
+		case <-ticker.C:
+		}
+
+		// Batches are removed until there are no more expired items, or the database is closed
+		for {
+			n, err := db.removeExpiredBatch()
+			if err != nil {
+				log.Printf("failed removing expired items: %s", err)
+				break
+			}
+			if n < db.expiryBatchSize {
+				break
+			}
+			select {
+			case <-db.stopReaper:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// stopReaping stops removing expired items in the background, and waits for a batch that is being removed.
+func (db *DB) stopReaping() {
+	if db.reaperDone == nil {
+		return
+	}
+	close(db.stopReaper)
+	<-db.reaperDone
+	db.reaperDone = nil
+}
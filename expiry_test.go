@@ -0,0 +1,254 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// setTestClock makes the database check ttls against a clock the test moves, and returns a function advancing it.
+func setTestClock(db *DB) func(d time.Duration) {
+	now := time.Unix(1000, 0)
+	db.now = func() time.Time {
+		return now
+	}
+	return func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func requireCursorKeys(t *testing.T, collection *Collection, expected ...string) {
+	var keys []string
+	cursor := collection.Cursor()
+	key, _, err := cursor.First()
+	for ; key != nil; key, _, err = cursor.Next() {
+		keys = append(keys, string(key))
+	}
+	require.NoError(t, err)
+	require.Equal(t, expected, keys)
+}
+
+func TestCollection_PutWithTTL(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("a"), []byte("1")))
+	require.NoError(t, collection.PutWithTTL([]byte("b"), []byte("2"), time.Minute))
+	require.NoError(t, collection.PutWithTTL([]byte("c"), []byte("3"), time.Hour))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	item, err := collection.Find([]byte("b"))
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	requireCursorKeys(t, collection, "a", "b", "c")
+	tx.Rollback()
+
+	advance(time.Minute)
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	item, err = collection.Find([]byte("b"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	requireCursorKeys(t, collection, "a", "c")
+	key, _, err := collection.Cursor().Seek([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("c"), key)
+
+	// An expired key is absent for conditional writes
+	deleted, err := collection.DeleteIfEquals([]byte("b"), []byte("2"))
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	put, err := collection.PutIfAbsent([]byte("b"), []byte("4"))
+	require.NoError(t, err)
+	assert.True(t, put)
+
+	// Putting a key again without a ttl clears its expiry
+	require.NoError(t, collection.Put([]byte("c"), []byte("5")))
+	require.NoError(t, tx.Commit())
+
+	advance(time.Hour)
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireCursorKeys(t, collection, "a", "b", "c")
+	tx.Rollback()
+}
+
+func TestCollection_PutWithTTLInvalid(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.ErrorIs(t, collection.PutWithTTL([]byte("a"), []byte("1"), 0), ErrInvalidTTL)
+	assert.ErrorIs(t, collection.PutWithTTL(make([]byte, MaxTTLKeySize+1), []byte("1"), time.Minute), ErrKeyTooLarge)
+	require.NoError(t, collection.PutWithTTL(make([]byte, MaxTTLKeySize), []byte("1"), time.Minute))
+}
+
+func TestDB_RemoveExpired(t *testing.T) {
+	options := indexTestOptions(cityIndex)
+	options.ExpiryBatchSize = 7
+	db, err := OpenStorage(NewMemoryStorage(), options)
+	require.NoError(t, err)
+	defer db.Close()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	other, err := tx.CreateCollection([]byte("other"))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value := []byte(fmt.Sprintf("city%d:name", i%2))
+		require.NoError(t, collection.PutWithTTL(key, value, time.Duration(i%4+1)*time.Minute))
+		require.NoError(t, other.PutWithTTL(key, value, time.Minute))
+	}
+	// Removing a key clears its expiry
+	require.NoError(t, collection.Remove([]byte("key000")))
+	require.NoError(t, tx.Commit())
+
+	removed, err := db.RemoveExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	watcher := db.Watch(testCollectionName, nil)
+	defer watcher.Close()
+
+	advance(2 * time.Minute)
+	removed, err = db.RemoveExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 49+100, removed)
+
+	change := <-watcher.Events
+	assert.Equal(t, ChangeDelete, change.Type)
+	// Keys are removed in the order they expired
+	assert.Equal(t, []byte("key004"), change.Key)
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "city", "city0", "key002", "key006", "key010", "key014", "key018", "key022", "key026",
+		"key030", "key034", "key038", "key042", "key046", "key050", "key054", "key058", "key062", "key066", "key070",
+		"key074", "key078", "key082", "key086", "key090", "key094", "key098")
+	other, err = tx.GetCollection([]byte("other"))
+	require.NoError(t, err)
+	requireCursorKeys(t, other)
+
+	// Expiry collections are hidden
+	collections, err := tx.Collections()
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
+	tx.Rollback()
+
+	advance(2 * time.Minute)
+	removed, err = db.RemoveExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 50, removed)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations)
+}
+
+func TestDB_ExpiryReaper(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), &Options{
+		MinFillPercent: testMinPercentage,
+		MaxFillPercent: testMaxPercentage,
+		ExpiryInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	watcher := db.Watch(testCollectionName, nil)
+	defer watcher.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.PutWithTTL([]byte("a"), []byte("1"), time.Millisecond))
+	require.NoError(t, tx.Commit())
+
+	<-watcher.Events
+	select {
+	case change := <-watcher.Events:
+		assert.Equal(t, ChangeDelete, change.Type)
+		assert.Equal(t, []byte("a"), change.Key)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the expired item wasn't removed")
+	}
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	expiryIndex, err := tx.getCollection(append([]byte(expiryIndexKeyPrefix), testCollectionName...))
+	require.NoError(t, err)
+	key, _, err := expiryIndex.Cursor().First()
+	require.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestDB_ExpiryDeletedWithCollection(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.PutWithTTL([]byte("a"), []byte("1"), time.Minute))
+	require.NoError(t, tx.DeleteCollection(testCollectionName))
+
+	cursor := tx.getRootCollection().Cursor()
+	for key, _, err := cursor.First(); key != nil; key, _, err = cursor.Next() {
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(key, testCollectionName), "%q wasn't deleted", key)
+	}
+}
+
+func TestCollection_ExpiryLookedUpOncePerTx(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("a"), []byte("1")))
+	assert.Equal(t, map[string]bool{string(testCollectionName): false}, tx.expiring)
+
+	// A ttl put through another handle of the collection is seen by the first one
+	other, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, other.PutWithTTL([]byte("b"), []byte("2"), time.Second))
+	advance(2 * time.Second)
+	item, err := collection.Find([]byte("b"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	// Expiry collections created after a savepoint are forgotten when it's rolled back to
+	savepoint := tx.Savepoint()
+	another, err := tx.CreateCollection([]byte("another"))
+	require.NoError(t, err)
+	require.NoError(t, another.PutWithTTL([]byte("c"), []byte("3"), time.Second))
+	require.NoError(t, tx.RollbackTo(savepoint))
+	assert.Empty(t, tx.expiring)
+	another, err = tx.CreateCollection([]byte("another"))
+	require.NoError(t, err)
+	require.NoError(t, another.Put([]byte("c"), []byte("3")))
+	require.NoError(t, tx.Commit())
+
+	requireCheckOK(t, db)
+}
@@ -0,0 +1,299 @@
+package LibraDB
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Encoding is how binary collection names, keys and values are written in exported JSON.
+type Encoding string
+
+const (
+	Base64Encoding Encoding = "base64"
+	HexEncoding    Encoding = "hex"
+	// StringEncoding writes the bytes as JSON strings. It can only be used when all the data is valid UTF-8.
+	StringEncoding Encoding = "string"
+)
+
+const defaultImportBatchSize = 1000
+
+type ExportOptions struct {
+	// Encoding defaults to Base64Encoding.
+	Encoding Encoding
+	// Collections limits the export to the given collections. All collections are exported if it's empty.
+	Collections [][]byte
+}
+
+type ImportOptions struct {
+	// Encoding must match the encoding the data was exported with. It defaults to Base64Encoding.
+	Encoding Encoding
+	// BatchSize is the maximum number of records written in a single transaction. It defaults to 1000.
+	BatchSize int
+}
+
+// exportRecord is a single line of an export. Each line holds either one key/value pair, with the time it expires at
+// if it has a ttl, or the metadata of a collection, along with the collection it belongs to. The metadata of a
+// collection comes before its pairs.
+type exportRecord struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key,omitempty"`
+	Value      string          `json:"value,omitempty"`
+	ExpiresAt  *time.Time      `json:"expiresAt,omitempty"`
+	Metadata   *exportMetadata `json:"metadata,omitempty"`
+}
+
+// exportMetadata holds the sequence of a collection, and the names of its comparator and merge operator.
+type exportMetadata struct {
+	Sequence      uint64 `json:"sequence"`
+	Comparator    string `json:"comparator,omitempty"`
+	MergeOperator string `json:"mergeOperator,omitempty"`
+}
+
+func (e Encoding) encode(b []byte) (string, error) {
+	switch e {
+	case "", Base64Encoding:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case HexEncoding:
+		return hex.EncodeToString(b), nil
+	case StringEncoding:
+		if !utf8.Valid(b) {
+			return "", fmt.Errorf("%q is not valid UTF-8, use a binary encoding", b)
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", e)
+	}
+}
+
+func (e Encoding) decode(s string) ([]byte, error) {
+	switch e {
+	case "", Base64Encoding:
+		return base64.StdEncoding.DecodeString(s)
+	case HexEncoding:
+		return hex.DecodeString(s)
+	case StringEncoding:
+		return []byte(s), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", e)
+	}
+}
+
+// Export writes the key/value pairs of the collections to w as JSON Lines, one pair per line, ordered by collection
+// and then by key. Every collection starts with a line holding its sequence and the names of its comparator and merge
+// operator. Pairs put with a ttl hold the time they expire at, and expired pairs aren't exported.
+func (tx *tx) Export(w io.Writer, options *ExportOptions) error {
+	var collections []*Collection
+	if len(options.Collections) == 0 {
+		var err error
+		collections, err = tx.Collections()
+		if err != nil {
+			return err
+		}
+	} else {
+		collections = make([]*Collection, 0, len(options.Collections))
+		for _, name := range options.Collections {
+			collection, err := tx.GetCollection(name)
+			if err != nil {
+				return err
+			}
+			if collection == nil {
+				return fmt.Errorf("collection %q doesn't exist", name)
+			}
+			collections = append(collections, collection)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, collection := range collections {
+		name, err := options.Encoding.encode(collection.name)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(&exportRecord{
+			Collection: name,
+			Metadata: &exportMetadata{
+				Sequence:      collection.counter,
+				Comparator:    collection.comparator,
+				MergeOperator: collection.mergeOperator,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		times, err := collection.expiryCollection(expiryTimesKeyPrefix, false)
+		if err != nil {
+			return err
+		}
+
+		cursor := collection.Cursor()
+		key, value, err := cursor.First()
+		for ; key != nil && err == nil; key, value, err = cursor.Next() {
+			record := exportRecord{Collection: name}
+			record.Key, err = options.Encoding.encode(key)
+			if err != nil {
+				return err
+			}
+			record.Value, err = options.Encoding.encode(value)
+			if err != nil {
+				return err
+			}
+			if times != nil {
+				record.ExpiresAt, err = times.expiresAt(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = encoder.Encode(&record)
+			if err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Import reads JSON Lines written by Export and puts the key/value pairs in their collections, creating the
+// collections that don't exist with the comparator they were exported with. The sequence of a collection is moved
+// forward to the exported one, and the name of its merge operator is stored with it. Pairs exported with the time they
+// expire at expire at the same time once imported. The records are written in
+// transactions of at most options.BatchSize records, so a failure leaves the batches before it committed. The number of records committed is returned.
+func (db *DB) Import(r io.Reader, options *ImportOptions) (int, error) {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	decoder := json.NewDecoder(r)
+	imported := 0
+	for {
+		n, err := db.importBatch(decoder, options.Encoding, batchSize)
+		if err != nil {
+			return imported, fmt.Errorf("record %d: %w", imported+n+1, err)
+		}
+		imported += n
+		if n < batchSize {
+			return imported, nil
+		}
+	}
+}
+
+// importBatch imports up to batchSize records in a single transaction. It returns the number of records imported,
+// which is less than batchSize only once the input is exhausted.
+func (db *DB) importBatch(decoder *json.Decoder, encoding Encoding, batchSize int) (int, error) {
+	tx := db.WriteTx()
+	collections := map[string]*Collection{}
+
+	n := 0
+	for ; n < batchSize; n++ {
+		record := exportRecord{}
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = tx.importRecord(&record, encoding, collections)
+		}
+		if err != nil {
+			tx.Rollback()
+			return n, err
+		}
+	}
+
+	return n, tx.Commit()
+}
+
+func (tx *tx) importRecord(record *exportRecord, encoding Encoding, collections map[string]*Collection) error {
+	name, err := encoding.decode(record.Collection)
+	if err != nil {
+		return err
+	}
+	if record.Metadata != nil {
+		return tx.importMetadata(name, record.Metadata, collections)
+	}
+
+	key, err := encoding.decode(record.Key)
+	if err != nil {
+		return err
+	}
+	value, err := encoding.decode(record.Value)
+	if err != nil {
+		return err
+	}
+
+	collection, err := tx.importCollection(name, &CollectionOptions{}, collections)
+	if err != nil {
+		return err
+	}
+	if record.ExpiresAt != nil {
+		if len(key) > MaxTTLKeySize {
+			return ErrKeyTooLarge
+		}
+		_, err = collection.putUntil(key, *record.ExpiresAt, func(current *Item) ([]byte, bool, error) {
+			return value, true, nil
+		})
+		return err
+	}
+	return collection.Put(key, value)
+}
+
+// importMetadata creates the collection with its comparator if it doesn't exist, and restores its sequence and merge
+// operator.
+func (tx *tx) importMetadata(name []byte, metadata *exportMetadata, collections map[string]*Collection) error {
+	collection, err := tx.importCollection(name, &CollectionOptions{Comparator: metadata.Comparator}, collections)
+	if err != nil {
+		return err
+	}
+	if collection.comparator != metadata.Comparator {
+		return fmt.Errorf("collection %q exists with comparator %q instead of %q", name, collection.comparator, metadata.Comparator)
+	}
+
+	if metadata.MergeOperator != "" && metadata.MergeOperator != collection.mergeOperator {
+		if collection.mergeOperator != "" {
+			return fmt.Errorf("collection %q was merged with %q instead of %q", name, collection.mergeOperator, metadata.MergeOperator)
+		}
+		collection.mergeOperator = metadata.MergeOperator
+		err = tx.updateCollection(collection)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Ids handed out before the export may be used by the imported keys, so the sequence never goes back
+	if metadata.Sequence > collection.counter {
+		return collection.SetSequence(metadata.Sequence)
+	}
+	return nil
+}
+
+// importCollection returns the collection with the given name, creating it with the options if it doesn't exist.
+func (tx *tx) importCollection(name []byte, options *CollectionOptions, collections map[string]*Collection) (*Collection, error) {
+	collection, ok := collections[string(name)]
+	if ok {
+		return collection, nil
+	}
+
+	collection, err := tx.GetCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	if collection == nil {
+		collection, err = tx.CreateCollectionWithOptions(name, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	collections[string(name)] = collection
+	return collection, nil
+}
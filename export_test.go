@@ -0,0 +1,269 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, encoding := range []Encoding{Base64Encoding, HexEncoding, StringEncoding} {
+		t.Run(string(encoding), func(t *testing.T) {
+			db, cleanFunc := createTestDB(t)
+			defer cleanFunc()
+
+			tx := db.WriteTx()
+			for _, name := range []string{"a", "b"} {
+				collection, err := tx.CreateCollection([]byte(name))
+				require.NoError(t, err)
+				for _, key := range []string{"0", "1", "2", "3", "4", "5", "6"} {
+					val := createItem(key)
+					require.NoError(t, collection.Put(val, val))
+				}
+			}
+			require.NoError(t, tx.Commit())
+
+			exported := bytes.Buffer{}
+			tx = db.ReadTx()
+			err := tx.Export(&exported, &ExportOptions{Encoding: encoding})
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+			// A line with the metadata of each collection, followed by its pairs
+			assert.Equal(t, 16, strings.Count(exported.String(), "\n"))
+
+			importedDB, importedCleanFunc := createTestDB(t)
+			defer importedCleanFunc()
+
+			n, err := importedDB.Import(bytes.NewReader(exported.Bytes()), &ImportOptions{Encoding: encoding, BatchSize: 3})
+			require.NoError(t, err)
+			assert.Equal(t, 16, n)
+
+			tx = db.ReadTx()
+			importedTx := importedDB.ReadTx()
+			for _, name := range []string{"a", "b"} {
+				expected, err := tx.GetCollection([]byte(name))
+				require.NoError(t, err)
+				actual, err := importedTx.GetCollection([]byte(name))
+				require.NoError(t, err)
+				require.NotNil(t, actual)
+
+				for _, key := range []string{"0", "1", "2", "3", "4", "5", "6"} {
+					expectedItem, err := expected.Find(createItem(key))
+					require.NoError(t, err)
+					actualItem, err := actual.Find(createItem(key))
+					require.NoError(t, err)
+					assert.Equal(t, expectedItem.value, actualItem.value)
+				}
+			}
+			require.NoError(t, importedTx.Commit())
+			require.NoError(t, tx.Commit())
+		})
+	}
+}
+
+func TestExportImport_CollectionMetadata(t *testing.T) {
+	options := func() *Options {
+		options := comparatorTestOptions()
+		options.MergeOperators = map[string]MergeOperator{"counters": CounterMerge}
+		return options
+	}
+	db, err := OpenStorage(NewMemoryStorage(), options())
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	numbers, err := tx.CreateCollectionWithOptions([]byte("numbers"), &CollectionOptions{Comparator: "numeric"})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		id, err := numbers.NextSequence()
+		require.NoError(t, err)
+		require.NoError(t, numbers.Put([]byte(fmt.Sprint(id*5)), []byte("value")))
+	}
+	counters, err := tx.CreateCollection([]byte("counters"))
+	require.NoError(t, err)
+	require.NoError(t, counters.Merge([]byte("visits"), EncodeCounter(2)))
+	_, err = tx.CreateCollection([]byte("empty"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	exported := bytes.Buffer{}
+	tx = db.ReadTx()
+	require.NoError(t, tx.Export(&exported, &ExportOptions{}))
+	tx.Rollback()
+
+	importedDB, err := OpenStorage(NewMemoryStorage(), options())
+	require.NoError(t, err)
+	defer importedDB.Close()
+	_, err = importedDB.Import(bytes.NewReader(exported.Bytes()), &ImportOptions{})
+	require.NoError(t, err)
+
+	tx = importedDB.WriteTx()
+	numbers, err = tx.GetCollection([]byte("numbers"))
+	require.NoError(t, err)
+	assert.Equal(t, "numeric", numbers.comparator)
+	requireCursorKeys(t, numbers, "5", "10", "15")
+	// The sequence continues after the ids of the imported keys
+	id, err := numbers.NextSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), id)
+
+	counters, err = tx.GetCollection([]byte("counters"))
+	require.NoError(t, err)
+	assert.Equal(t, CounterMerge.Name(), counters.mergeOperator)
+	require.NoError(t, counters.Merge([]byte("visits"), EncodeCounter(3)))
+	item, err := counters.Find([]byte("visits"))
+	require.NoError(t, err)
+	n, err := DecodeCounter(item.Value())
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	empty, err := tx.GetCollection([]byte("empty"))
+	require.NoError(t, err)
+	assert.NotNil(t, empty)
+	require.NoError(t, tx.Commit())
+
+	// The merge operator isn't needed to import the collection, only to merge it
+	unregistered, err := OpenStorage(NewMemoryStorage(), comparatorTestOptions())
+	require.NoError(t, err)
+	defer unregistered.Close()
+	_, err = unregistered.Import(bytes.NewReader(exported.Bytes()), &ImportOptions{})
+	require.NoError(t, err)
+
+	tx = unregistered.WriteTx()
+	defer tx.Rollback()
+	counters, err = tx.GetCollection([]byte("counters"))
+	require.NoError(t, err)
+	assert.Equal(t, CounterMerge.Name(), counters.mergeOperator)
+	item, err = counters.Find([]byte("visits"))
+	require.NoError(t, err)
+	assert.Equal(t, EncodeCounter(2), item.Value())
+	assert.ErrorIs(t, counters.Merge([]byte("visits"), EncodeCounter(1)), ErrNoMergeOperator)
+}
+
+func TestExport_SelectedCollections(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	a, err := tx.CreateCollection([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, a.Put([]byte("key"), []byte("value")))
+	b, err := tx.CreateCollection([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, b.Put([]byte("key"), []byte{0xff}))
+
+	exported := bytes.Buffer{}
+	err = tx.Export(&exported, &ExportOptions{Encoding: StringEncoding, Collections: [][]byte{[]byte("a")}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"collection":"a","metadata":{"sequence":0}}`+"\n"+`{"collection":"a","key":"key","value":"value"}`+"\n", exported.String())
+
+	// Binary data can't be exported as strings
+	err = tx.Export(&bytes.Buffer{}, &ExportOptions{Encoding: StringEncoding})
+	assert.Error(t, err)
+
+	err = tx.Export(&bytes.Buffer{}, &ExportOptions{Collections: [][]byte{[]byte("c")}})
+	assert.Error(t, err)
+	require.NoError(t, tx.Commit())
+}
+
+func TestExport_SelectedCollectionsOnly(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	db, err := Open(path, comparatorTestOptions())
+	require.NoError(t, err)
+	tx := db.WriteTx()
+	_, err = tx.CreateCollectionWithOptions([]byte("numbers"), &CollectionOptions{Comparator: "numeric"})
+	require.NoError(t, err)
+	a, err := tx.CreateCollection([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, a.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// Only the selected collections are opened, so the comparator of the others isn't needed
+	db, err = Open(path, DefaultOptions)
+	require.NoError(t, err)
+	defer db.Close()
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	exported := bytes.Buffer{}
+	err = tx.Export(&exported, &ExportOptions{Encoding: StringEncoding, Collections: [][]byte{[]byte("a")}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"collection":"a","metadata":{"sequence":0}}`+"\n"+`{"collection":"a","key":"key","value":"value"}`+"\n", exported.String())
+
+	err = tx.Export(&bytes.Buffer{}, &ExportOptions{})
+	assert.ErrorIs(t, err, ErrUnknownComparator)
+}
+
+func TestExportImport_TTL(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.PutWithTTL([]byte("short"), []byte("value"), time.Minute))
+	require.NoError(t, collection.PutWithTTL([]byte("long"), []byte("value"), time.Hour))
+	require.NoError(t, collection.PutWithTTL([]byte("expired"), []byte("value"), time.Second))
+	require.NoError(t, collection.Put([]byte("forever"), []byte("value")))
+	require.NoError(t, tx.Commit())
+	advance(time.Second)
+
+	exported := bytes.Buffer{}
+	tx = db.ReadTx()
+	require.NoError(t, tx.Export(&exported, &ExportOptions{Encoding: StringEncoding}))
+	tx.Rollback()
+	assert.Contains(t, exported.String(), `"expiresAt":"`+time.Unix(1060, 0).Format(time.RFC3339Nano)+`"`)
+	assert.NotContains(t, exported.String(), "expired")
+
+	imported, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+	advance = setTestClock(imported)
+	n, err := imported.Import(bytes.NewReader(exported.Bytes()), &ImportOptions{Encoding: StringEncoding})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	// The pairs expire at the time they were exported with
+	advance(2 * time.Minute)
+	tx = imported.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireCursorKeys(t, collection, "forever", "long")
+}
+
+func TestImport_FailedBatchIsRolledBack(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	input := `{"collection":"a","key":"1","value":"1"}
+{"collection":"a","key":"2","value":"2"}
+{"collection":"a","key":"3","value":"3"}
+{"collection":"a","key":"4","value":"4"}
+{"collection":"a","key":"5","value":"5"}
+not json
+`
+	n, err := db.Import(strings.NewReader(input), &ImportOptions{Encoding: StringEncoding, BatchSize: 2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "record 6")
+	assert.Equal(t, 4, n)
+
+	tx := db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection([]byte("a"))
+	require.NoError(t, err)
+	item, err := collection.Find([]byte("4"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("4"), item.value)
+
+	item, err = collection.Find([]byte("5"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
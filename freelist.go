@@ -13,6 +13,10 @@ type freelist struct {
 	// maxPage is incremented and a new page is created thus increasing the file size.
 	maxPage       pgnum
 	releasedPages []pgnum
+
+	// overflowPages holds the pages the freelist continues at when its released pages don't fit in the freelist page
+	// of the meta. Each page points to the next one.
+	overflowPages []pgnum
 }
 
 func newFreelist() *freelist {
@@ -39,25 +43,61 @@ func (fr *freelist) releasePage(page pgnum) {
 	fr.releasedPages = append(fr.releasedPages, page)
 }
 
+// serializedSize returns the number of bytes needed to serialize the freelist in a single page: max page, released
+// pages count, the released pages, the next page and the full max page.
+func (fr *freelist) serializedSize() int {
+	return freelistHeaderSize + len(fr.releasedPages)*pageNumSize + freelistTrailerSize
+}
+
+// pageCapacity returns how many released pages a single page of the given size holds, along with its header and
+// trailer.
+func pageCapacity(pageSize int) int {
+	return (pageSize - freelistHeaderSize - freelistTrailerSize) / pageNumSize
+}
+
 func (fr *freelist) serialize(buf []byte) []byte {
+	return fr.serializePage(buf, fr.releasedPages, 0)
+}
+
+// serializePage writes a page of the freelist holding the given released pages, followed by the page the freelist
+// continues at, or 0 on the last page, and the max page. The header keeps the lower 16 bits of the max page, where
+// files written by earlier versions stored it, and the full value is written after the next page.
+func (fr *freelist) serializePage(buf []byte, releasedPages []pgnum, next pgnum) []byte {
 	pos := 0
 
 	binary.LittleEndian.PutUint16(buf[pos:], uint16(fr.maxPage))
 	pos += 2
 
 	// released pages count
-	binary.LittleEndian.PutUint16(buf[pos:], uint16(len(fr.releasedPages)))
+	binary.LittleEndian.PutUint16(buf[pos:], uint16(len(releasedPages)))
 	pos += 2
 
-	for _, page := range fr.releasedPages {
+	for _, page := range releasedPages {
 		binary.LittleEndian.PutUint64(buf[pos:], uint64(page))
 		pos += pageNumSize
 
 	}
+
+	binary.LittleEndian.PutUint64(buf[pos:], uint64(next))
+	pos += pageNumSize
+
+	binary.LittleEndian.PutUint64(buf[pos:], uint64(fr.maxPage))
+	pos += pageNumSize
 	return buf
 }
 
-func (fr *freelist) deserialize(buf []byte) {
+func (fr *freelist) deserialize(buf []byte) error {
+	_, err := fr.deserializePage(buf)
+	return err
+}
+
+// deserializePage reads a page of the freelist, adding its released pages, and returns the page the freelist continues
+// at, or 0 on the last page.
+func (fr *freelist) deserializePage(buf []byte) (pgnum, error) {
+	if len(buf) < freelistHeaderSize {
+		return 0, corruptedPageErr
+	}
+
 	pos := 0
 	fr.maxPage = pgnum(binary.LittleEndian.Uint16(buf[pos:]))
 	pos += 2
@@ -65,9 +105,28 @@ func (fr *freelist) deserialize(buf []byte) {
 	// released pages count
 	releasedPagesCount := int(binary.LittleEndian.Uint16(buf[pos:]))
 	pos += 2
+	if len(buf) < pos+releasedPagesCount*pageNumSize {
+		return 0, corruptedPageErr
+	}
 
 	for i := 0; i < releasedPagesCount; i++ {
 		fr.releasedPages = append(fr.releasedPages, pgnum(binary.LittleEndian.Uint64(buf[pos:])))
 		pos += pageNumSize
 	}
+
+	// Freelists written before they could span several pages have no pointer nor full max page, and are followed by
+	// zeroes.
+	if len(buf) < pos+pageNumSize {
+		return 0, nil
+	}
+	next := pgnum(binary.LittleEndian.Uint64(buf[pos:]))
+	pos += pageNumSize
+
+	if len(buf) < pos+pageNumSize {
+		return next, nil
+	}
+	if maxPage := pgnum(binary.LittleEndian.Uint64(buf[pos:])); maxPage != 0 {
+		fr.maxPage = maxPage
+	}
+	return next, nil
 }
@@ -1,8 +1,10 @@
 package LibraDB
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math/rand"
 	"os"
 	"testing"
 )
@@ -22,8 +24,8 @@ func TestFreelistSerialize(t *testing.T) {
 func TestFreelistDeserialize(t *testing.T) {
 	freelist, err := os.ReadFile(getExpectedResultFileName(t.Name()))
 	actual := newFreelist()
-	actual.deserialize(freelist)
 	require.NoError(t, err)
+	require.NoError(t, actual.deserialize(freelist))
 
 	expected := newFreelist()
 	expected.maxPage = 5
@@ -31,3 +33,90 @@ func TestFreelistDeserialize(t *testing.T) {
 
 	assert.Equal(t, expected, actual)
 }
+
+func FuzzFreelistDeserialize(f *testing.F) {
+	buf, err := os.ReadFile(getExpectedResultFileName("TestFreelistDeserialize"))
+	require.NoError(f, err)
+	f.Add(buf)
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		fr := newFreelist()
+		if fr.deserialize(buf) != nil {
+			return
+		}
+
+		// Serializing it again gives back the freelist it was read from
+		reserialized := fr.serialize(make([]byte, fr.serializedSize()))
+		actual := newFreelist()
+		require.NoError(t, actual.deserialize(reserialized))
+		assert.Equal(t, fr, actual)
+	})
+}
+
+func TestFreelistLargeMaxPage(t *testing.T) {
+	freelist := newFreelist()
+	freelist.maxPage = 1<<16 + 5
+	freelist.releasedPages = []pgnum{1, 1 << 16, 1<<16 + 1}
+	buf := freelist.serializePage(make([]byte, testPageSize), freelist.releasedPages, 1<<16+2)
+
+	actual := newFreelist()
+	next, err := actual.deserializePage(buf)
+	require.NoError(t, err)
+	assert.Equal(t, pgnum(1<<16+2), next)
+	assert.Equal(t, freelist, actual)
+}
+
+func TestFreelistSpansPages(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	db, err := Open(path, &Options{MinFillPercent: 0.5, MaxFillPercent: 0.95})
+	require.NoError(t, err)
+
+	// Every batch puts random keys all over the tree, so each commit releases more pages than a single freelist page
+	// holds.
+	r := rand.New(rand.NewSource(1))
+	value := make([]byte, 200)
+	expected := map[string]bool{}
+	overflowed := false
+	for batch := 0; batch < 10; batch++ {
+		tx := db.WriteTx()
+		collection, err := tx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+		if collection == nil {
+			collection, err = tx.CreateCollection(testCollectionName)
+			require.NoError(t, err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("%016x", r.Uint64())
+			require.NoError(t, collection.Put([]byte(key), value))
+			expected[key] = true
+		}
+		require.NoError(t, tx.Commit())
+		overflowed = overflowed || len(db.overflowPages) > 0
+	}
+	assert.True(t, overflowed)
+	requireCheckOK(t, db)
+	require.NoError(t, db.Close())
+
+	// The overflow pages are read back
+	db, err = Open(path, &Options{MinFillPercent: 0.5, MaxFillPercent: 0.95})
+	require.NoError(t, err)
+	defer db.Close()
+	report := requireCheckOK(t, db)
+	assert.Equal(t, len(db.releasedPages), report.FreePages)
+
+	tx := db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	n := 0
+	cursor := collection.Cursor()
+	key, _, err := cursor.First()
+	for ; key != nil; key, _, err = cursor.Next() {
+		assert.True(t, expected[string(key)])
+		n++
+	}
+	require.NoError(t, err)
+	assert.Equal(t, len(expected), n)
+}
@@ -0,0 +1,334 @@
+package LibraDB
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrUnknownIndex = errors.New("the index isn't registered in Options.Indexes")
+	ErrInvalidIndex = errors.New("the index must have a name, a collection and an extractor, and be unique per collection")
+	// ErrUniqueViolation is returned when an item has a key in a unique index that another item already has.
+	ErrUniqueViolation = errors.New("another key already has the same key in a unique index")
+)
+
+// indexKeyPrefix is the prefix of the hidden collections holding the entries of the indexes.
+const indexKeyPrefix = "\x00index:"
+
+// Index is a secondary index of a collection. Extract returns the index keys of an item, and the item can then be found
+// by any of them with Collection.Lookup and Collection.LookupRange. An item may have any number of index keys,
+// including none. Indexes must be registered in Options.Indexes every time the database is opened, and are kept up to
+// date by every write to their collection from then on. Extract must be deterministic, as the keys an item had are
+// computed again when it changes.
+type Index struct {
+	Name       string
+	Collection []byte
+	Extract    func(key []byte, value []byte) [][]byte
+	// Unique makes writes return ErrUniqueViolation rather than give an item an index key another item already has.
+	Unique bool
+}
+
+// collectionName returns the name of the hidden collection holding the entries of the index. The length of the
+// collection name is part of it, so indexes of different collections never share it.
+func (index *Index) collectionName() []byte {
+	name := make([]byte, 0, len(indexKeyPrefix)+1+len(index.Collection)+len(index.Name))
+	name = append(name, indexKeyPrefix...)
+	name = append(name, byte(len(index.Collection)))
+	name = append(name, index.Collection...)
+	return append(name, index.Name...)
+}
+
+// checkIndexes returns ErrInvalidIndex if an index can't be registered.
+func checkIndexes(indexes []*Index) error {
+	names := map[string]bool{}
+	for _, index := range indexes {
+		if index.Name == "" || index.Extract == nil || checkCollectionName(index.Collection) != nil {
+			return ErrInvalidIndex
+		}
+		name := string(index.collectionName())
+		if len(name) > MaxKeySize || names[name] {
+			return ErrInvalidIndex
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// entries returns the index entries of an item. An entry is the index key, escaped and terminated so entries are
+// ordered by index key first, followed by the primary key.
+func (index *Index) entries(key []byte, value []byte) ([][]byte, error) {
+	indexKeys := index.Extract(key, value)
+	entries := make([][]byte, 0, len(indexKeys))
+	for _, indexKey := range indexKeys {
+		entry := appendTerminated(make([]byte, 0, len(indexKey)+len(key)+2), indexKey)
+		entry = append(entry, key...)
+		if len(entry) > MaxKeySize {
+			return nil, ErrKeyTooLarge
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// indexUpdate holds the entries of an index that are removed and added by a write.
+type indexUpdate struct {
+	index   *Index
+	removed [][]byte
+	added   [][]byte
+}
+
+// indexUpdates computes the changes to the indexes of the collection when the current item is replaced by the updated
+// one. current is nil when the key is added, and updated is nil when it's removed.
+func (c *Collection) indexUpdates(current *Item, updated *Item) ([]*indexUpdate, error) {
+	if c.isRoot {
+		return nil, nil
+	}
+
+	var updates []*indexUpdate
+	for _, index := range c.tx.db.indexes[string(c.name)] {
+		var oldEntries, newEntries [][]byte
+		var err error
+		if current != nil {
+			oldEntries, err = index.entries(current.key, current.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if updated != nil {
+			newEntries, err = index.entries(updated.key, updated.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		update := &indexUpdate{index: index}
+		kept := map[string]bool{}
+		for _, entry := range oldEntries {
+			kept[string(entry)] = true
+		}
+		for _, entry := range newEntries {
+			if kept[string(entry)] {
+				delete(kept, string(entry))
+				continue
+			}
+			if index.Unique {
+				err = c.checkUnique(index, entry, updated.key)
+				if err != nil {
+					return nil, err
+				}
+			}
+			update.added = append(update.added, entry)
+		}
+		for _, entry := range oldEntries {
+			if kept[string(entry)] {
+				update.removed = append(update.removed, entry)
+			}
+		}
+		if len(update.removed) > 0 || len(update.added) > 0 {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+func (c *Collection) applyIndexUpdates(updates []*indexUpdate) error {
+	for _, update := range updates {
+		indexCollection, err := c.tx.indexCollection(update.index)
+		if err != nil {
+			return err
+		}
+		for _, entry := range update.removed {
+			err = indexCollection.Remove(entry)
+			if err != nil {
+				return err
+			}
+		}
+		for _, entry := range update.added {
+			err = indexCollection.Put(entry, []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkUnique returns ErrUniqueViolation if the index has an entry with the same index key as the given entry of the
+// key, that belongs to another key. Entries of keys whose ttl passed are ignored, as those keys are absent.
+func (c *Collection) checkUnique(index *Index, entry []byte, key []byte) error {
+	indexCollection, err := c.tx.getCollection(index.collectionName())
+	if err != nil || indexCollection == nil {
+		return err
+	}
+
+	// The index key of the entry, escaped and terminated
+	prefix := entry[:len(entry)-len(key)]
+	cursor := indexCollection.Cursor()
+	existing, _, err := cursor.Seek(prefix)
+	for ; existing != nil && bytes.HasPrefix(existing, prefix); existing, _, err = cursor.Next() {
+		// The key compares equal to itself when it's written with different bytes by a comparator
+		other := existing[len(prefix):]
+		if c.compare()(other, key) == 0 {
+			continue
+		}
+		expired, err := c.isExpired(other)
+		if err != nil {
+			return err
+		}
+		if !expired {
+			return ErrUniqueViolation
+		}
+	}
+	return err
+}
+
+// indexCollection returns the hidden collection of the index. It's created if it doesn't exist in a write transaction,
+// and nil is returned if it doesn't exist in a read transaction.
+func (tx *tx) indexCollection(index *Index) (*Collection, error) {
+	collection, err := tx.getCollection(index.collectionName())
+	if err != nil || collection != nil || !tx.write {
+		return collection, err
+	}
+	return tx.createCollectionWithOptions(index.collectionName(), &CollectionOptions{})
+}
+
+// index returns the index of the collection with the given name.
+func (c *Collection) index(name string) (*Index, error) {
+	for _, index := range c.tx.db.indexes[string(c.name)] {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return nil, ErrUnknownIndex
+}
+
+// Lookup returns the items that have the given key in the index, ordered by their keys.
+func (c *Collection) Lookup(index string, indexKey []byte) ([]*Item, error) {
+	// The smallest key that comes after indexKey
+	end := append(append([]byte{}, indexKey...), 0)
+	return c.LookupRange(index, indexKey, end)
+}
+
+// LookupRange returns the items that have a key in the index from start, inclusive, to end, exclusive, ordered by the
+// index key and then by their keys. A nil start or end leaves the range unbounded on that side. An item that has more
+// than one key in the range is returned once for each of them.
+func (c *Collection) LookupRange(index string, start []byte, end []byte) ([]*Item, error) {
+	i, err := c.index(index)
+	if err != nil {
+		return nil, err
+	}
+	indexCollection, err := c.tx.indexCollection(i)
+	if err != nil || indexCollection == nil {
+		return nil, err
+	}
+
+	var items []*Item
+	cursor := indexCollection.Cursor()
+	entry, _, err := cursor.Seek(appendEscaped(nil, start))
+	for ; entry != nil; entry, _, err = cursor.Next() {
+		indexKey, key, err := splitTerminated(entry)
+		if err != nil {
+			return nil, err
+		}
+		if end != nil && bytes.Compare(indexKey, end) >= 0 {
+			break
+		}
+
+		item, err := c.Find(key)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+	return items, err
+}
+
+// RebuildIndex removes every entry of the index and adds the entries of the items of the collection again. It's needed
+// after the index was changed, or registered for a collection that already had items. ErrUniqueViolation is returned
+// if the index is unique and two items have the same index key.
+func (c *Collection) RebuildIndex(name string) error {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return err
+	}
+	index, err := c.index(name)
+	if err != nil {
+		return err
+	}
+	indexCollection, err := c.tx.indexCollection(index)
+	if err != nil {
+		return err
+	}
+
+	err = indexCollection.Truncate()
+	if err != nil {
+		return err
+	}
+
+	cursor := c.Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil; key, value, err = cursor.Next() {
+		entries, err := index.entries(key, value)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if index.Unique {
+				err = c.checkUnique(index, entry, key)
+				if err != nil {
+					return err
+				}
+			}
+			err = indexCollection.Put(entry, []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// Bytes of index keys are escaped: 0x00 is written as 0x00 0xff, and the key ends with 0x00 0x01.
+const (
+	keyEscape     = 0x00
+	keyEscaped    = 0xff
+	keyTerminator = 0x01
+)
+
+// appendEscaped appends v with its 0x00 bytes escaped.
+func appendEscaped(b []byte, v []byte) []byte {
+	for _, x := range v {
+		b = append(b, x)
+		if x == keyEscape {
+			b = append(b, keyEscaped)
+		}
+	}
+	return b
+}
+
+// appendTerminated appends v escaped and terminated, so it can be followed by other bytes without changing its order.
+func appendTerminated(b []byte, v []byte) []byte {
+	return append(appendEscaped(b, v), keyEscape, keyTerminator)
+}
+
+// splitTerminated splits bytes written by appendTerminated from the bytes that follow them.
+func splitTerminated(b []byte) ([]byte, []byte, error) {
+	v := make([]byte, 0, len(b))
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] != keyEscape {
+			v = append(v, b[i])
+			continue
+		}
+		if b[i+1] == keyTerminator {
+			return v, b[i+2:], nil
+		}
+		if b[i+1] != keyEscaped {
+			return nil, nil, ErrInvalidEncoding
+		}
+		v = append(v, keyEscape)
+		i++
+	}
+	return nil, nil, ErrInvalidEncoding
+}
@@ -0,0 +1,439 @@
+package LibraDB
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// cityIndex indexes values of the form "city:name" by their city.
+var cityIndex = &Index{
+	Name:       "city",
+	Collection: testCollectionName,
+	Extract: func(key []byte, value []byte) [][]byte {
+		city, _, found := bytes.Cut(value, []byte(":"))
+		if !found {
+			return nil
+		}
+		return [][]byte{city}
+	},
+}
+
+// tagsIndex indexes values of the form "tag,tag,..." by each of their tags.
+var tagsIndex = &Index{
+	Name:       "tags",
+	Collection: testCollectionName,
+	Extract: func(key []byte, value []byte) [][]byte {
+		if len(value) == 0 {
+			return nil
+		}
+		return bytes.Split(value, []byte(","))
+	},
+}
+
+func indexTestOptions(indexes ...*Index) *Options {
+	return &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage, Indexes: indexes}
+}
+
+func itemKeys(items []*Item) []string {
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, string(item.Key()))
+	}
+	return keys
+}
+
+func requireLookup(t *testing.T, collection *Collection, index string, indexKey string, expected ...string) {
+	items, err := collection.Lookup(index, []byte(indexKey))
+	require.NoError(t, err)
+	require.Equal(t, expected, itemKeys(items))
+}
+
+func TestIndex_Lookup(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("paris:alice")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("london:bob")))
+	require.NoError(t, collection.Put([]byte("3"), []byte("paris:carol")))
+	require.NoError(t, collection.Put([]byte("4"), []byte("no city")))
+	require.NoError(t, collection.Put([]byte("5"), []byte("par:dave")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	requireLookup(t, collection, "city", "paris", "1", "3")
+	requireLookup(t, collection, "city", "london", "2")
+	requireLookup(t, collection, "city", "par", "5")
+	requireLookup(t, collection, "city", "rome")
+
+	items, err := collection.Lookup("city", []byte("london"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("london:bob"), items[0].Value())
+
+	items, err = collection.LookupRange("city", []byte("m"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "1", "3"}, itemKeys(items))
+	items, err = collection.LookupRange("city", nil, []byte("paris"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "5"}, itemKeys(items))
+
+	_, err = collection.Lookup("unknown", []byte("paris"))
+	assert.ErrorIs(t, err, ErrUnknownIndex)
+}
+
+func TestIndex_UpdatedByWrites(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex, tagsIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("a,b")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("b,c")))
+	requireLookup(t, collection, "tags", "b", "1", "2")
+
+	require.NoError(t, collection.Put([]byte("1"), []byte("a,c")))
+	requireLookup(t, collection, "tags", "a", "1")
+	requireLookup(t, collection, "tags", "b", "2")
+	requireLookup(t, collection, "tags", "c", "1", "2")
+
+	swapped, err := collection.CompareAndSwap([]byte("2"), []byte("b,c"), []byte("d"))
+	require.NoError(t, err)
+	require.True(t, swapped)
+	requireLookup(t, collection, "tags", "c", "1")
+	requireLookup(t, collection, "tags", "d", "2")
+
+	require.NoError(t, collection.Remove([]byte("1")))
+	requireLookup(t, collection, "tags", "a")
+	requireLookup(t, collection, "tags", "c")
+
+	deleted, err := collection.DeleteIfEquals([]byte("2"), []byte("other"))
+	require.NoError(t, err)
+	require.False(t, deleted)
+	requireLookup(t, collection, "tags", "d", "2")
+	deleted, err = collection.DeleteIfEquals([]byte("2"), []byte("d"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	requireLookup(t, collection, "tags", "d")
+	require.NoError(t, tx.Commit())
+
+	// Enough items for multi level index trees
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, collection.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("city%d:name", i%3))))
+	}
+	for i := 0; i < 300; i += 2 {
+		require.NoError(t, collection.Remove([]byte(fmt.Sprintf("key%03d", i))))
+	}
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	items, err := collection.Lookup("city", []byte("city1"))
+	require.NoError(t, err)
+	require.Len(t, items, 50)
+	for _, item := range items {
+		assert.Equal(t, []byte("city1:name"), item.Value())
+	}
+
+	// Index collections are hidden
+	collections, err := tx.Collections()
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	tx.Rollback()
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations)
+}
+
+func TestIndex_RolledBack(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("paris:alice")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("london:alice")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("paris:bob")))
+	requireLookup(t, collection, "city", "paris", "2")
+	tx.Rollback()
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "city", "paris", "1")
+	requireLookup(t, collection, "city", "london")
+}
+
+func TestIndex_EntryTooLarge(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	value := append(bytes.Repeat([]byte("a"), MaxKeySize), ':')
+	err = collection.Put([]byte("1"), value)
+	assert.ErrorIs(t, err, ErrKeyTooLarge)
+
+	// Nothing was put
+	item, err := collection.Find([]byte("1"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	db, err := Open(path, indexTestOptions())
+	require.NoError(t, err)
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("paris:alice")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("london:bob")))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// Items put before the index was registered are found only once it's rebuilt
+	db, err = Open(path, indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("3"), []byte("paris:carol")))
+	requireLookup(t, collection, "city", "paris", "3")
+
+	require.NoError(t, collection.RebuildIndex("city"))
+	requireLookup(t, collection, "city", "paris", "1", "3")
+	requireLookup(t, collection, "city", "london", "2")
+	assert.ErrorIs(t, collection.RebuildIndex("unknown"), ErrUnknownIndex)
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "city", "paris", "1", "3")
+	assert.ErrorIs(t, collection.RebuildIndex("city"), writeInsideReadTxErr)
+	tx.Rollback()
+}
+
+func TestIndex_DeletedWithCollection(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("paris:alice")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	require.NoError(t, tx.DeleteCollection(testCollectionName))
+	indexCollection, err := tx.getCollection(cityIndex.collectionName())
+	require.NoError(t, err)
+	assert.Nil(t, indexCollection)
+
+	collection, err = tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "city", "paris")
+	require.NoError(t, tx.Commit())
+}
+
+func TestIndex_Invalid(t *testing.T) {
+	extract := func(key []byte, value []byte) [][]byte {
+		return nil
+	}
+	for _, indexes := range [][]*Index{
+		{{Name: "", Collection: testCollectionName, Extract: extract}},
+		{{Name: "name", Collection: testCollectionName}},
+		{{Name: "name", Collection: []byte{0x00}, Extract: extract}},
+		{{Name: string(make([]byte, MaxKeySize)), Collection: testCollectionName, Extract: extract}},
+		{cityIndex, {Name: "city", Collection: testCollectionName, Extract: extract}},
+	} {
+		_, err := OpenStorage(NewMemoryStorage(), indexTestOptions(indexes...))
+		assert.ErrorIs(t, err, ErrInvalidIndex)
+	}
+}
+
+func TestIndex_Unique(t *testing.T) {
+	emailIndex := &Index{
+		Name:       "email",
+		Collection: testCollectionName,
+		Extract: func(key []byte, value []byte) [][]byte {
+			return [][]byte{value}
+		},
+		Unique: true,
+	}
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(emailIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("a@example.com")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("b@example.com")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.ErrorIs(t, collection.Put([]byte("3"), []byte("a@example.com")), ErrUniqueViolation)
+	assert.ErrorIs(t, collection.Put([]byte("2"), []byte("a@example.com")), ErrUniqueViolation)
+	_, err = collection.PutIfAbsent([]byte("3"), []byte("b@example.com"))
+	assert.ErrorIs(t, err, ErrUniqueViolation)
+
+	// Nothing was changed by the failed writes
+	item, err := collection.Find([]byte("3"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	item, err = collection.Find([]byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b@example.com"), item.Value())
+	requireLookup(t, collection, "email", "a@example.com", "1")
+	requireLookup(t, collection, "email", "b@example.com", "2")
+
+	// An item can be put again with its own index key, and the key can be taken once it's released
+	require.NoError(t, collection.Put([]byte("1"), []byte("a@example.com")))
+	require.NoError(t, collection.Put([]byte("1"), []byte("c@example.com")))
+	require.NoError(t, collection.Put([]byte("3"), []byte("a@example.com")))
+	require.NoError(t, collection.Remove([]byte("2")))
+	require.NoError(t, collection.Put([]byte("4"), []byte("b@example.com")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "email", "a@example.com", "3")
+	requireLookup(t, collection, "email", "b@example.com", "4")
+	requireLookup(t, collection, "email", "c@example.com", "1")
+	tx.Rollback()
+}
+
+func TestIndex_UniqueExpired(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(&Index{
+		Name:       "email",
+		Collection: testCollectionName,
+		Extract: func(key []byte, value []byte) [][]byte {
+			return [][]byte{value}
+		},
+		Unique: true,
+	}))
+	require.NoError(t, err)
+	defer db.Close()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.PutWithTTL([]byte("a"), []byte("x"), time.Second))
+	assert.ErrorIs(t, collection.Put([]byte("b"), []byte("x")), ErrUniqueViolation)
+	require.NoError(t, tx.Commit())
+
+	// The index key of an expired item is free, even before the item is removed
+	advance(2 * time.Second)
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("b"), []byte("x")))
+	assert.ErrorIs(t, collection.Put([]byte("c"), []byte("x")), ErrUniqueViolation)
+	requireLookup(t, collection, "email", "x", "b")
+	require.NoError(t, tx.Commit())
+
+	removed, err := db.RemoveExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireLookup(t, collection, "email", "x", "b")
+	tx.Rollback()
+}
+
+func TestIndex_UniqueRebuild(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(&Index{
+		Name:       "city",
+		Collection: testCollectionName,
+		Extract:    cityIndex.Extract,
+		Unique:     true,
+	}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("1"), []byte("paris:alice")))
+	require.NoError(t, collection.Put([]byte("2"), []byte("london:bob")))
+	require.NoError(t, collection.RebuildIndex("city"))
+
+	// Items put before the index was registered may violate it
+	_, err = collection.putItem([]byte("3"), func(current *Item) ([]byte, bool, error) {
+		return []byte("paris:carol"), true, nil
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, collection.RebuildIndex("city"), ErrUniqueViolation)
+}
+
+func TestIndex_UniqueWithComparator(t *testing.T) {
+	options := comparatorTestOptions()
+	options.Indexes = []*Index{{
+		Name:       "value",
+		Collection: testCollectionName,
+		Extract: func(key []byte, value []byte) [][]byte {
+			return [][]byte{value}
+		},
+		Unique: true,
+	}}
+	db, err := OpenStorage(NewMemoryStorage(), options)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollectionWithOptions(testCollectionName, &CollectionOptions{Comparator: "case-insensitive"})
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("alice"), []byte("1")))
+	// The same key written differently keeps its index key
+	require.NoError(t, collection.Put([]byte("ALICE"), []byte("1")))
+	assert.ErrorIs(t, collection.Put([]byte("bob"), []byte("1")), ErrUniqueViolation)
+
+	items, err := collection.Lookup("value", []byte("1"))
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, []byte("ALICE"), items[0].Key())
+}
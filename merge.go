@@ -0,0 +1,187 @@
+package LibraDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrNoMergeOperator     = errors.New("the collection has no merge operator")
+	ErrInvalidMergeOperand = errors.New("the operand or the existing value doesn't match the merge operator")
+	// ErrUnknownMergeOperator is returned by Merge when the collection was merged with an operator other than the one
+	// registered for it.
+	ErrUnknownMergeOperator = errors.New("the merge operator the collection was merged with isn't registered for it in Options.MergeOperators")
+	ErrMergeOperatorName    = errors.New("the merge operator name is too long")
+)
+
+// maxMergeOperatorNameSize is the longest merge operator name, which is stored with the collection after the name of
+// its comparator.
+const maxMergeOperatorNameSize = MaxValueSize - collectionSize - 2 - maxComparatorNameSize
+
+// MergeOperator combines the existing value of a key with an operand into its new value. existing is nil if the key
+// doesn't exist. Merge operators are set per collection with Options.MergeOperators. The name of the operator is stored
+// with the collection once it's merged, so it's merged only with an operator of the same name from then on. Reading the
+// collection doesn't need the operator.
+type MergeOperator interface {
+	Name() string
+	Merge(existing []byte, operand []byte) ([]byte, error)
+}
+
+type mergeOperator struct {
+	name  string
+	merge func(existing []byte, operand []byte) ([]byte, error)
+}
+
+func (o *mergeOperator) Name() string {
+	return o.name
+}
+
+func (o *mergeOperator) Merge(existing []byte, operand []byte) ([]byte, error) {
+	return o.merge(existing, operand)
+}
+
+// NewMergeOperator returns a merge operator with the given name that merges with fn.
+func NewMergeOperator(name string, fn func(existing []byte, operand []byte) ([]byte, error)) MergeOperator {
+	return &mergeOperator{name, fn}
+}
+
+var (
+	// CounterMerge adds the operand to the existing value. Both are int64 encoded with EncodeCounter, and a missing
+	// value counts as 0.
+	CounterMerge = NewMergeOperator("counter", mergeCounter)
+	// AppendMerge appends the operand to the existing value.
+	AppendMerge = NewMergeOperator("append", mergeAppend)
+	// SetUnionMerge adds the elements of the operand to the existing set. Both are sets encoded with EncodeSet.
+	SetUnionMerge = NewMergeOperator("set-union", mergeSetUnion)
+	// MaxMerge keeps the biggest of the existing value and the operand, comparing them as bytes.
+	MaxMerge = NewMergeOperator("max", mergeMax)
+)
+
+// Merge combines the current value of the key with the operand using the merge operator of the collection, and puts
+// the result. The current value is read in the same descent that finds where the result is put.
+func (c *Collection) Merge(key []byte, operand []byte) error {
+	operator, ok := c.tx.db.mergeOperators[string(c.name)]
+	if !ok {
+		return ErrNoMergeOperator
+	}
+	if c.mergeOperator != "" && c.mergeOperator != operator.Name() {
+		return ErrUnknownMergeOperator
+	}
+
+	if c.mergeOperator == "" {
+		if len(operator.Name()) > maxMergeOperatorNameSize {
+			return ErrMergeOperatorName
+		}
+		c.mergeOperator = operator.Name()
+		err := c.tx.updateCollection(c)
+		if err != nil {
+			c.mergeOperator = ""
+			return err
+		}
+	}
+
+	_, err := c.put(key, func(current *Item) ([]byte, bool, error) {
+		var existing []byte
+		if current != nil {
+			existing = current.value
+		}
+		value, err := operator.Merge(existing, operand)
+		if err != nil {
+			return nil, false, fmt.Errorf("merge operator %s: %w", operator.Name(), err)
+		}
+		return value, true, nil
+	})
+	return err
+}
+
+// EncodeCounter encodes a counter for CounterMerge.
+func EncodeCounter(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// DecodeCounter decodes a counter encoded with EncodeCounter.
+func DecodeCounter(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidMergeOperand
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func mergeCounter(existing []byte, operand []byte) ([]byte, error) {
+	delta, err := DecodeCounter(operand)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return EncodeCounter(delta), nil
+	}
+	n, err := DecodeCounter(existing)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeCounter(n + delta), nil
+}
+
+func mergeAppend(existing []byte, operand []byte) ([]byte, error) {
+	value := make([]byte, 0, len(existing)+len(operand))
+	value = append(value, existing...)
+	return append(value, operand...), nil
+}
+
+func mergeMax(existing []byte, operand []byte) ([]byte, error) {
+	if existing != nil && bytes.Compare(existing, operand) >= 0 {
+		return existing, nil
+	}
+	return operand, nil
+}
+
+// EncodeSet encodes a set for SetUnionMerge. The elements are sorted and deduplicated, and each is prefixed with its
+// length, so elements can be up to 255 bytes long.
+func EncodeSet(elements ...[]byte) []byte {
+	sorted := make([][]byte, len(elements))
+	copy(sorted, elements)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	b := make([]byte, 0)
+	for i, element := range sorted {
+		if i > 0 && bytes.Equal(element, sorted[i-1]) {
+			continue
+		}
+		b = append(b, byte(len(element)))
+		b = append(b, element...)
+	}
+	return b
+}
+
+// DecodeSet decodes a set encoded with EncodeSet.
+func DecodeSet(b []byte) ([][]byte, error) {
+	elements := make([][]byte, 0)
+	for len(b) > 0 {
+		size := int(b[0])
+		if 1+size > len(b) {
+			return nil, ErrInvalidMergeOperand
+		}
+		elements = append(elements, b[1:1+size])
+		b = b[1+size:]
+	}
+	return elements, nil
+}
+
+func mergeSetUnion(existing []byte, operand []byte) ([]byte, error) {
+	existingElements, err := DecodeSet(existing)
+	if err != nil {
+		return nil, err
+	}
+	operandElements, err := DecodeSet(operand)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSet(append(existingElements, operandElements...)...), nil
+}
@@ -0,0 +1,188 @@
+package LibraDB
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func createMergeTestDB(t *testing.T) *DB {
+	db, err := OpenStorage(NewMemoryStorage(), &Options{
+		MinFillPercent: testMinPercentage,
+		MaxFillPercent: testMaxPercentage,
+		MergeOperators: map[string]MergeOperator{
+			"counters": CounterMerge,
+			"lists":    AppendMerge,
+			"sets":     SetUnionMerge,
+			"maxes":    MaxMerge,
+			"custom": NewMergeOperator("min", func(existing []byte, operand []byte) ([]byte, error) {
+				if existing != nil && bytes.Compare(existing, operand) <= 0 {
+					return existing, nil
+				}
+				return operand, nil
+			}),
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func mergeAll(t *testing.T, db *DB, collectionName string, key []byte, operands ...[]byte) []byte {
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection([]byte(collectionName))
+	require.NoError(t, err)
+	for _, operand := range operands {
+		require.NoError(t, collection.Merge(key, operand))
+	}
+	require.NoError(t, tx.Commit())
+
+	readTx := db.ReadTx()
+	defer readTx.Rollback()
+	collection, err = readTx.GetCollection([]byte(collectionName))
+	require.NoError(t, err)
+	item, err := collection.Find(key)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	return item.value
+}
+
+func TestCollection_Merge(t *testing.T) {
+	db := createMergeTestDB(t)
+
+	value := mergeAll(t, db, "counters", []byte("visits"), EncodeCounter(5), EncodeCounter(-2), EncodeCounter(10))
+	n, err := DecodeCounter(value)
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), n)
+
+	value = mergeAll(t, db, "lists", []byte("log"), []byte("a"), []byte("bc"), []byte("d"))
+	assert.Equal(t, []byte("abcd"), value)
+
+	value = mergeAll(t, db, "sets", []byte("tags"), EncodeSet([]byte("go"), []byte("db")), EncodeSet([]byte("db"), []byte("btree")))
+	elements, err := DecodeSet(value)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("btree"), []byte("db"), []byte("go")}, elements)
+
+	value = mergeAll(t, db, "maxes", []byte("latest"), []byte("2022-03"), []byte("2023-01"), []byte("2022-12"))
+	assert.Equal(t, []byte("2023-01"), value)
+
+	value = mergeAll(t, db, "custom", []byte("earliest"), []byte("2022-03"), []byte("2021-01"), []byte("2022-12"))
+	assert.Equal(t, []byte("2021-01"), value)
+}
+
+func TestCollection_MergeErrors(t *testing.T) {
+	db := createMergeTestDB(t)
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.ErrorIs(t, collection.Merge([]byte("key"), []byte("value")), ErrNoMergeOperator)
+
+	counters, err := tx.CreateCollection([]byte("counters"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, counters.Merge([]byte("key"), []byte("not a counter")), ErrInvalidMergeOperand)
+	item, err := counters.Find([]byte("key"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	lists, err := tx.CreateCollection([]byte("lists"))
+	require.NoError(t, err)
+	require.NoError(t, lists.Merge([]byte("key"), make([]byte, MaxValueSize)))
+	assert.ErrorIs(t, lists.Merge([]byte("key"), []byte("a")), ErrValueTooLarge)
+	item, err = lists.Find([]byte("key"))
+	require.NoError(t, err)
+	assert.Len(t, item.value, MaxValueSize)
+}
+
+func TestCollection_MergeOperatorStored(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+	open := func(operators map[string]MergeOperator) *DB {
+		db, err := Open(path, &Options{
+			MinFillPercent: testMinPercentage,
+			MaxFillPercent: testMaxPercentage,
+			MergeOperators: operators,
+		})
+		require.NoError(t, err)
+		return db
+	}
+
+	db := open(map[string]MergeOperator{"counters": CounterMerge})
+	tx := db.WriteTx()
+	counters, err := tx.CreateCollection([]byte("counters"))
+	require.NoError(t, err)
+	_, err = tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	// A merge rolled back to a savepoint doesn't store the operator
+	savepoint := tx.Savepoint()
+	require.NoError(t, counters.Merge([]byte("visits"), EncodeCounter(1)))
+	require.NoError(t, tx.RollbackTo(savepoint))
+	assert.Empty(t, counters.mergeOperator)
+	require.NoError(t, counters.Merge([]byte("visits"), EncodeCounter(2)))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// The collection is read without the operator it was merged with, but it can't be merged with another one
+	for _, operators := range []map[string]MergeOperator{nil, {"counters": AppendMerge}} {
+		db = open(operators)
+		tx = db.WriteTx()
+		counters, err = tx.GetCollection([]byte("counters"))
+		require.NoError(t, err)
+		item, err := counters.Find([]byte("visits"))
+		require.NoError(t, err)
+		assert.Equal(t, EncodeCounter(2), item.Value())
+		collections, err := tx.Collections()
+		require.NoError(t, err)
+		assert.Len(t, collections, 2)
+		err = counters.Merge([]byte("visits"), EncodeCounter(1))
+		if operators == nil {
+			assert.ErrorIs(t, err, ErrNoMergeOperator)
+		} else {
+			assert.ErrorIs(t, err, ErrUnknownMergeOperator)
+		}
+		tx.Rollback()
+		require.NoError(t, db.Close())
+	}
+
+	db = open(map[string]MergeOperator{"counters": CounterMerge})
+	defer db.Close()
+	tx = db.WriteTx()
+	counters, err = tx.GetCollection([]byte("counters"))
+	require.NoError(t, err)
+	require.NoError(t, counters.Merge([]byte("visits"), EncodeCounter(3)))
+	item, err := counters.Find([]byte("visits"))
+	require.NoError(t, err)
+	n, err := DecodeCounter(item.Value())
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	require.NoError(t, tx.Commit())
+
+	requireCheckOK(t, db)
+}
+
+func TestSerializeCollectionWithMergeOperator(t *testing.T) {
+	for _, comparator := range []string{"", "numeric"} {
+		collection := &Collection{
+			name:          []byte("collection1"),
+			root:          1,
+			counter:       2,
+			comparator:    comparator,
+			mergeOperator: "counter",
+		}
+
+		item := collection.serialize()
+		assert.True(t, isValidCollectionValue(item.value))
+		actual := newEmptyCollection()
+		actual.deserialize(item)
+		assert.Equal(t, collection, actual)
+
+		assert.False(t, isValidCollectionValue(item.value[:len(item.value)-1]))
+	}
+}
@@ -1,6 +1,9 @@
 package LibraDB
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
 
 const (
 	magicNumber uint32 = 0xD00DB00D
@@ -15,6 +18,10 @@ type meta struct {
 	// and the root page are located, a search inside a collection can be made.
 	root         pgnum
 	freelistPage pgnum
+
+	// txid is incremented on every commit. The meta page holds two slots and commits alternate between them, so a
+	// failure while writing one slot leaves the other one intact. The valid slot with the highest txid is used.
+	txid uint64
 }
 
 func newEmptyMeta() *meta {
@@ -31,15 +38,25 @@ func (m *meta) serialize(buf []byte) {
 
 	binary.LittleEndian.PutUint64(buf[pos:], uint64(m.freelistPage))
 	pos += pageNumSize
+
+	binary.LittleEndian.PutUint64(buf[pos:], m.txid)
+	pos += txidSize
+
+	binary.LittleEndian.PutUint32(buf[pos:], crc32.ChecksumIEEE(buf[:pos]))
+	pos += checksumSize
 }
 
-func (m *meta) deserialize(buf []byte) {
+func (m *meta) deserialize(buf []byte) error {
+	if len(buf) < magicNumberSize+2*pageNumSize {
+		return invalidMetaErr
+	}
+
 	pos := 0
 	magicNumberRes := binary.LittleEndian.Uint32(buf[pos:])
 	pos += magicNumberSize
 
 	if magicNumberRes != magicNumber {
-		panic("The file is not a libra db file")
+		return invalidMetaErr
 	}
 
 	m.root = pgnum(binary.LittleEndian.Uint64(buf[pos:]))
@@ -47,4 +64,28 @@ func (m *meta) deserialize(buf []byte) {
 
 	m.freelistPage = pgnum(binary.LittleEndian.Uint64(buf[pos:]))
 	pos += pageNumSize
+
+	// Meta pages written before the txid was added end here
+	if len(buf) < pos+txidSize {
+		return nil
+	}
+	m.txid = binary.LittleEndian.Uint64(buf[pos:])
+	pos += txidSize
+	return nil
+}
+
+// isValidMeta checks the buffer holds a meta slot that was completely written. Files created before meta slots had a
+// checksum have both the txid and the checksum zeroed, so they are accepted as well.
+func isValidMeta(buf []byte) bool {
+	if len(buf) < metaSize || binary.LittleEndian.Uint32(buf) != magicNumber {
+		return false
+	}
+
+	checksumPos := metaSize - checksumSize
+	checksum := binary.LittleEndian.Uint32(buf[checksumPos:])
+	txid := binary.LittleEndian.Uint64(buf[checksumPos-txidSize:])
+	if checksum == 0 && txid == 0 {
+		return true
+	}
+	return checksum == crc32.ChecksumIEEE(buf[:checksumPos])
 }
@@ -24,20 +24,32 @@ func TestCreateDalIncorrectMagicNumber(t *testing.T) {
 	actualMetaBytes, err := os.ReadFile(getExpectedResultFileName(t.Name()))
 	require.NoError(t, err)
 	actualMeta := newEmptyMeta()
-	assert.Panics(t, func() {
-		actualMeta.deserialize(actualMetaBytes)
-	})
+	assert.ErrorIs(t, actualMeta.deserialize(actualMetaBytes), invalidMetaErr)
 }
 
 func TestMetaDeserialize(t *testing.T) {
 	actualMetaBytes, err := os.ReadFile(getExpectedResultFileName(t.Name()))
 	actualMeta := newEmptyMeta()
-	actualMeta.deserialize(actualMetaBytes)
 	require.NoError(t, err)
+	require.NoError(t, actualMeta.deserialize(actualMetaBytes))
 
 	expectedMeta := newEmptyMeta()
 	expectedMeta.root = 3
 	expectedMeta.freelistPage = 4
 
 	assert.Equal(t, expectedMeta, actualMeta)
-}
\ No newline at end of file
+}
+
+func FuzzMetaDeserialize(f *testing.F) {
+	for _, name := range []string{"TestMetaDeserialize", "TestCreateDalIncorrectMagicNumber"} {
+		buf, err := os.ReadFile(getExpectedResultFileName(name))
+		require.NoError(f, err)
+		f.Add(buf)
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		m := newEmptyMeta()
+		_ = m.deserialize(buf)
+		_ = isValidMeta(buf)
+	})
+}
@@ -1,7 +1,6 @@
 package LibraDB
 
 import (
-	"bytes"
 	"encoding/binary"
 )
 
@@ -38,6 +37,14 @@ func newItem(key []byte, value []byte) *Item {
 	}
 }
 
+func (i *Item) Key() []byte {
+	return i.key
+}
+
+func (i *Item) Value() []byte {
+	return i.value
+}
+
 func isLast(index int, parentNode *Node) bool {
 	return index == len(parentNode.items)
 }
@@ -69,13 +76,12 @@ func (n *Node) isOverPopulated() bool {
 	return n.tx.db.isOverPopulated(n)
 }
 
-// canSpareAnElement checks if the node size is big enough to populate a page after giving away one item.
-func (n *Node) canSpareAnElement() bool {
-	splitIndex := n.tx.db.getSplitIndex(n)
-	if splitIndex == -1 {
+// canSpareAnElement checks if the node size is big enough to populate a page after giving away the item at the index.
+func (n *Node) canSpareAnElement(index int) bool {
+	if len(n.items) < 2 {
 		return false
 	}
-	return true
+	return float32(n.nodeSize()-n.elementSize(index)) >= n.tx.db.minThreshold()
 }
 
 // isUnderPopulated checks if the node size is smaller than the size of a page.
@@ -153,10 +159,15 @@ func (n *Node) serialize(buf []byte) []byte {
 	return buf
 }
 
-func (n *Node) deserialize(buf []byte) {
+// deserialize reads a node from the page. Every offset and length is checked against the page, so a corrupted page
+// returns an error instead of panicking.
+func (n *Node) deserialize(buf []byte) error {
 	leftPos := 0
 
 	// Read header
+	if len(buf) < nodeHeaderSize {
+		return corruptedPageErr
+	}
 	isLeaf := uint16(buf[0])
 
 	itemsCount := int(binary.LittleEndian.Uint16(buf[1:3]))
@@ -165,6 +176,9 @@ func (n *Node) deserialize(buf []byte) {
 	// Read body
 	for i := 0; i < itemsCount; i++ {
 		if isLeaf == 0 { // False
+			if len(buf) < leftPos+pageNumSize {
+				return corruptedPageErr
+			}
 			pageNum := binary.LittleEndian.Uint64(buf[leftPos:])
 			leftPos += pageNumSize
 
@@ -172,18 +186,30 @@ func (n *Node) deserialize(buf []byte) {
 		}
 
 		// Read offset
-		offset := binary.LittleEndian.Uint16(buf[leftPos:])
+		if len(buf) < leftPos+2 {
+			return corruptedPageErr
+		}
+		offset := int(binary.LittleEndian.Uint16(buf[leftPos:]))
 		leftPos += 2
 
-		klen := uint16(buf[int(offset)])
+		if len(buf) < offset+1 {
+			return corruptedPageErr
+		}
+		klen := int(buf[offset])
 		offset += 1
 
+		if len(buf) < offset+klen+1 {
+			return corruptedPageErr
+		}
 		key := buf[offset : offset+klen]
 		offset += klen
 
-		vlen := uint16(buf[int(offset)])
+		vlen := int(buf[offset])
 		offset += 1
 
+		if len(buf) < offset+vlen {
+			return corruptedPageErr
+		}
 		value := buf[offset : offset+vlen]
 		offset += vlen
 		n.items = append(n.items, newItem(key, value))
@@ -191,9 +217,13 @@ func (n *Node) deserialize(buf []byte) {
 
 	if isLeaf == 0 { // False
 		// Read the last child node
+		if len(buf) < leftPos+pageNumSize {
+			return corruptedPageErr
+		}
 		pageNum := pgnum(binary.LittleEndian.Uint64(buf[leftPos:]))
 		n.childNodes = append(n.childNodes, pageNum)
 	}
+	return nil
 }
 
 // elementSize returns the size of a key-value-childNode triplet at a given index. If the node is a leaf, then the size
@@ -202,6 +232,7 @@ func (n *Node) elementSize(i int) int {
 	size := 0
 	size += len(n.items[i].key)
 	size += len(n.items[i].value)
+	size += itemOverheadSize // the offset and the key and value lengths
 	size += pageNumSize // 8 is the pgnum size
 	return size
 }
@@ -226,17 +257,17 @@ func (n *Node) nodeSize() int {
 // If the key isn't found, we have 2 options. If exact is true, it means we expect findKey
 // to find the key, so a falsey answer. If exact is false, then findKey is used to locate where a new key should be
 // inserted so the position is returned.
-func (n *Node) findKey(key []byte, exact bool) (int, *Node, []int ,error) {
+func (n *Node) findKey(key []byte, exact bool, compare Comparator) (int, *Node, []int ,error) {
 	ancestorsIndexes := []int{0} // index of root
-	index, node, err := findKeyHelper(n, key, exact, &ancestorsIndexes)
+	index, node, err := findKeyHelper(n, key, exact, compare, &ancestorsIndexes)
 	if err != nil {
 		return -1, nil, nil, err
 	}
 	return index, node, ancestorsIndexes, nil
 }
 
-func findKeyHelper(node *Node, key []byte, exact bool, ancestorsIndexes *[]int) (int, *Node ,error) {
-	wasFound, index := node.findKeyInNode(key)
+func findKeyHelper(node *Node, key []byte, exact bool, compare Comparator, ancestorsIndexes *[]int) (int, *Node ,error) {
+	wasFound, index := node.findKeyInNode(key, compare)
 	if wasFound {
 		return index, node, nil
 	}
@@ -253,20 +284,21 @@ func findKeyHelper(node *Node, key []byte, exact bool, ancestorsIndexes *[]int)
 	if err != nil {
 		return -1, nil, err
 	}
-	return findKeyHelper(nextChild, key, exact, ancestorsIndexes)
+	return findKeyHelper(nextChild, key, exact, compare, ancestorsIndexes)
 }
 
 // findKeyInNode iterates all the items and finds the key. If the key is found, then the item is returned. If the key
 // isn't found then return the index where it should have been (the first index that key is greater than it's previous)
-func (n *Node) findKeyInNode(key []byte) (bool, int) {
+// Keys are ordered by the comparator of the collection.
+func (n *Node) findKeyInNode(key []byte, compare Comparator) (bool, int) {
 	for i, existingItem := range n.items {
-		res := bytes.Compare(existingItem.key, key)
+		res := compare(existingItem.key, key)
 		if res == 0 { // Keys match
 			return true, i
 		}
 
 		// The key is bigger than the previous item, so it doesn't exist in the node, but may exist in child nodes.
-		if res == 1 {
+		if res > 0 {
 			return false, i
 		}
 	}
@@ -304,11 +336,15 @@ func (n *Node) split(nodeToSplit *Node, nodeToSplitIndex int) {
 	middleItem := nodeToSplit.items[splitIndex]
 	var newNode *Node
 
+	// The new node gets copies of the upper halves, otherwise it would share the backing arrays with nodeToSplit and
+	// later appends to nodeToSplit would overwrite its items.
+	newNodeItems := append([]*Item{}, nodeToSplit.items[splitIndex+1:]...)
 	if nodeToSplit.isLeaf() {
-		newNode = n.writeNode(n.tx.newNode(nodeToSplit.items[splitIndex+1:], []pgnum{}))
+		newNode = n.writeNode(n.tx.newNode(newNodeItems, []pgnum{}))
 		nodeToSplit.items = nodeToSplit.items[:splitIndex]
 	} else {
-		newNode = n.writeNode(n.tx.newNode(nodeToSplit.items[splitIndex+1:], nodeToSplit.childNodes[splitIndex+1:]))
+		newNodeChildNodes := append([]pgnum{}, nodeToSplit.childNodes[splitIndex+1:]...)
+		newNode = n.writeNode(n.tx.newNode(newNodeItems, newNodeChildNodes))
 		nodeToSplit.items = nodeToSplit.items[:splitIndex]
 		nodeToSplit.childNodes = nodeToSplit.childNodes[:splitIndex+1]
 	}
@@ -336,7 +372,7 @@ func (n *Node) rebalanceRemove(unbalancedNode *Node, unbalancedNodeIndex int) er
 		if err != nil {
 			return err
 		}
-		if leftNode.canSpareAnElement() {
+		if leftNode.canSpareAnElement(len(leftNode.items) - 1) {
 			rotateRight(leftNode, pNode, unbalancedNode, unbalancedNodeIndex)
 			n.writeNodes(leftNode, pNode, unbalancedNode)
 			return nil
@@ -349,7 +385,7 @@ func (n *Node) rebalanceRemove(unbalancedNode *Node, unbalancedNodeIndex int) er
 		if err != nil {
 			return err
 		}
-		if rightNode.canSpareAnElement() {
+		if rightNode.canSpareAnElement(0) {
 			rotateLeft(unbalancedNode, pNode, rightNode, unbalancedNodeIndex)
 			n.writeNodes(unbalancedNode, pNode, rightNode)
 			return nil
@@ -371,6 +407,22 @@ func (n *Node) rebalanceRemove(unbalancedNode *Node, unbalancedNodeIndex int) er
 	return pNode.merge(unbalancedNode, unbalancedNodeIndex)
 }
 
+// rebalanceUnderPopulated rebalances an under populated child until it's populated enough or merged into one of its
+// siblings. A single rotation may not be enough, since the item rotated in may be small as well. Once the child is
+// merged into its sibling, it's no longer a child of the node.
+func (n *Node) rebalanceUnderPopulated(child *Node, childIndex int) error {
+	for child.isUnderPopulated() && len(n.childNodes) > 1 && n.childNodes[childIndex] == child.pageNum {
+		err := n.rebalanceRemove(child, childIndex)
+		if err != nil {
+			return err
+		}
+		if childIndex >= len(n.childNodes) {
+			break
+		}
+	}
+	return nil
+}
+
 // removeItemFromLeaf removes an item from a leaf node. It means there is no handling of child nodes.
 func (n *Node) removeItemFromLeaf(index int) {
 	n.items = append(n.items[:index], n.items[index+1:]...)
@@ -397,7 +449,7 @@ func (n *Node) removeItemFromInternal(index int) ([]int, error) {
 	}
 
 	for !aNode.isLeaf() {
-		traversingIndex := len(n.childNodes) - 1
+		traversingIndex := len(aNode.childNodes) - 1
 		aNode, err = aNode.getNode(aNode.childNodes[traversingIndex])
 		if err != nil {
 			return nil, err
@@ -496,5 +548,11 @@ func (n *Node) merge(bNode *Node, bNodeIndex int) error {
 	}
 	n.writeNodes(aNode, n)
 	n.tx.deleteNode(bNode)
+
+	// When the items are big, the merged node may not fit in a page. Splitting it again moves the items between the
+	// two nodes so both are in bounds.
+	if aNode.isOverPopulated() {
+		n.split(aNode, bNodeIndex-1)
+	}
 	return nil
 }
\ No newline at end of file
@@ -864,6 +864,12 @@ func Test_FindNode(t *testing.T) {
 	err = tx.Commit()
 	require.NoError(t, err)
 
+	// The collection can't be used after its transaction was committed, so it's read again in a new transaction
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(collection.name)
+	require.NoError(t, err)
+
 	// Item found
 	expectedVal := createItem("c")
 	expectedItem := newItem(expectedVal, expectedVal)
@@ -952,7 +958,7 @@ func TestDeserializeWithoutChildNodes(t *testing.T) {
 	require.NoError(t, err)
 
 	actualNode := NewEmptyNode()
-	actualNode.deserialize(page)
+	require.NoError(t, actualNode.deserialize(page))
 
 	items := []*Item{newItem([]byte("key1"), []byte("val1")), newItem([]byte("key2"), []byte("val2"))}
 	var childNodes []pgnum
@@ -991,6 +997,26 @@ func TestDeserializeWithChildNodes(t *testing.T) {
 	}
 
 	actualNode := NewEmptyNode()
-	actualNode.deserialize(page)
+	require.NoError(t, actualNode.deserialize(page))
 	assert.Equal(t, expectedNode, actualNode)
 }
+
+func FuzzNodeDeserialize(f *testing.F) {
+	for _, name := range []string{"TestDeserializeWithChildNodes", "TestDeserializeWithoutChildNodes"} {
+		page, err := os.ReadFile(getExpectedResultFileName(name))
+		require.NoError(f, err)
+		f.Add(page)
+	}
+
+	f.Fuzz(func(t *testing.T, page []byte) {
+		node := NewEmptyNode()
+		if node.deserialize(page) != nil {
+			return
+		}
+
+		// A node that was read successfully has a child for every gap between items
+		if !node.isLeaf() {
+			assert.Equal(t, len(node.items)+1, len(node.childNodes))
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package LibraDB
+
+// DeleteRange removes the keys from start, inclusive, to end, exclusive. A nil start or end leaves the range unbounded
+// on that side. Child subtrees that are entirely inside the range are dropped without reading them, and their pages are
+// released on commit, so deleting a large range costs about as much as removing the keys at its edges. Collections with
+// indexes or keys with a ttl, and collections that are watched, are still removed key by key, as every removed key has
+// to be seen.
+func (c *Collection) DeleteRange(start []byte, end []byte) error {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return err
+	}
+	if start == nil && end == nil {
+		return c.Truncate()
+	}
+
+	byKey, err := c.needsKeyByKey()
+	if err != nil {
+		return err
+	}
+	if !byKey {
+		for {
+			cut, err := c.cutRange(start, end)
+			if err != nil {
+				return err
+			}
+			if !cut {
+				break
+			}
+		}
+	}
+
+	// What is left of the range are keys along its edges, or all of it if it's removed key by key. Expired keys are
+	// removed as well, so the cursor methods that don't skip them are used.
+	var keys [][]byte
+	cursor := c.Cursor()
+	key, _, err := cursor.seek(start)
+	for ; key != nil && (end == nil || c.compare()(key, end) < 0); key, _, err = cursor.next() {
+		keys = append(keys, key)
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = c.Remove(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate removes every key of the collection. Its pages are released on commit without reading its leaves, and the
+// entries of its indexes and the expiry times of its keys are dropped the same way. If the collection is watched, its
+// keys are read so the removal of those that didn't expire is reported.
+func (c *Collection) Truncate() error {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return err
+	}
+
+	if c.tx.db.isWatched() && !c.isRoot && !isReservedKey(c.name) {
+		cursor := c.Cursor()
+		key, value, err := cursor.First()
+		for ; key != nil; key, value, err = cursor.Next() {
+			c.tx.recordChange(c, ChangeDelete, key, value, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.tx.freeTree(c.root)
+	if err != nil {
+		return err
+	}
+	root := c.tx.newNode([]*Item{}, []pgnum{})
+	c.tx.markDirty(c, root)
+	c.root = root.pageNum
+	err = c.tx.updateCollection(c)
+	if err != nil {
+		return err
+	}
+
+	hidden, err := c.hiddenCollections()
+	if err != nil {
+		return err
+	}
+	for _, collection := range hidden {
+		err = collection.Truncate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hiddenCollections returns the existing hidden collections kept along with the collection.
+func (c *Collection) hiddenCollections() ([]*Collection, error) {
+	if c.isRoot || isReservedKey(c.name) {
+		return nil, nil
+	}
+
+	var collections []*Collection
+	for _, name := range c.tx.hiddenCollectionNames(c.name) {
+		collection, err := c.tx.getCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		if collection != nil {
+			collections = append(collections, collection)
+		}
+	}
+	return collections, nil
+}
+
+// hiddenCollectionNames returns the names of the hidden collections that may be kept along with a collection, holding
+// the entries of its indexes and the expiry times of its keys.
+func (tx *tx) hiddenCollectionNames(name []byte) [][]byte {
+	names := [][]byte{
+		append([]byte(expiryTimesKeyPrefix), name...),
+		append([]byte(expiryIndexKeyPrefix), name...),
+	}
+	for _, index := range tx.db.indexes[string(name)] {
+		names = append(names, index.collectionName())
+	}
+	return names
+}
+
+// needsKeyByKey returns whether the keys of the collection have to be removed one by one, since removing them updates
+// more than the collection itself.
+func (c *Collection) needsKeyByKey() (bool, error) {
+	if c.isRoot || isReservedKey(c.name) {
+		return false, nil
+	}
+	if len(c.tx.db.indexes[string(c.name)]) > 0 || c.tx.db.isWatched() {
+		return true, nil
+	}
+	times, err := c.expiryCollection(expiryTimesKeyPrefix, false)
+	return times != nil, err
+}
+
+// cutRange finds the topmost node that has child subtrees entirely inside the range, and drops them along with the
+// items between them. Only the last of these items is kept, as the separator of the two children at the edges of the
+// range. The nodes on the path to the node are then rebalanced. It returns whether such a node was found.
+func (c *Collection) cutRange(start []byte, end []byte) (bool, error) {
+	root, err := c.tx.getNode(c.root)
+	if err != nil {
+		return false, err
+	}
+	return c.cutRangeHelper(start, end, []*Node{root}, []int{0})
+}
+
+// cutRangeHelper looks for the node to cut below the last node of the path. The range of items inside the range in a
+// node is contiguous, so only the children at its edges are searched, and the search follows at most two paths.
+func (c *Collection) cutRangeHelper(start []byte, end []byte, path []*Node, indexes []int) (bool, error) {
+	node := path[len(path)-1]
+	if node.isLeaf() {
+		return false, nil
+	}
+
+	// The items from first to last, exclusive, are inside the range
+	first := 0
+	if start != nil {
+		_, first = node.findKeyInNode(start, c.compare())
+	}
+	last := len(node.items)
+	if end != nil {
+		_, last = node.findKeyInNode(end, c.compare())
+	}
+
+	// The children between two items inside the range are entirely inside it
+	if last-first >= 2 {
+		return true, c.cut(path, indexes, first, last)
+	}
+
+	edges := []int{first}
+	if last > first {
+		edges = append(edges, last)
+	}
+	for _, i := range edges {
+		child, err := node.getNode(node.childNodes[i])
+		if err != nil {
+			return false, err
+		}
+		cut, err := c.cutRangeHelper(start, end, append(path, child), append(indexes, i))
+		if err != nil || cut {
+			return cut, err
+		}
+	}
+	return false, nil
+}
+
+// cut removes the items of the last node of the path from first to last-1, exclusive, and frees the children between
+// them.
+func (c *Collection) cut(path []*Node, indexes []int, first int, last int) error {
+	c.tx.markDirty(c, path...)
+	node := path[len(path)-1]
+
+	dropped := append([]pgnum{}, node.childNodes[first+1:last]...)
+	node.items = append(node.items[:first], node.items[last-1:]...)
+	node.childNodes = append(node.childNodes[:first+1], node.childNodes[last:]...)
+
+	for _, pageNum := range dropped {
+		err := c.tx.freeTree(pageNum)
+		if err != nil {
+			return err
+		}
+	}
+	return c.rebalancePath(path, indexes)
+}
+
+// rebalancePath rebalances the nodes of a path from the root from the bottom up, after items were removed from them.
+// A node may have lost many items, so it's rotated with its siblings until it's populated enough or merged into one of
+// them.
+func (c *Collection) rebalancePath(path []*Node, indexes []int) error {
+	for i := len(path) - 1; i > 0; i-- {
+		pnode := path[i-1]
+		node := path[i]
+		if node.isOverPopulated() {
+			pnode.split(node, indexes[i])
+			continue
+		}
+		err := pnode.rebalanceUnderPopulated(node, indexes[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// If the root has no items after rebalancing, its only child becomes the root.
+	rootNode := path[0]
+	if len(rootNode.items) == 0 && len(rootNode.childNodes) > 0 {
+		c.root = rootNode.childNodes[0]
+		c.tx.deleteNode(rootNode)
+		return c.tx.updateCollection(c)
+	}
+	if rootNode.isOverPopulated() {
+		return c.splitRoot(rootNode)
+	}
+	return nil
+}
+
+// freeTree releases the pages of the subtree at the given page on commit. Every leaf is at the same depth, so the
+// height of the subtree is found first, and leaves are released without reading them.
+func (tx *tx) freeTree(pageNum pgnum) error {
+	height := 0
+	node, err := tx.getNode(pageNum)
+	if err != nil {
+		return err
+	}
+	for !node.isLeaf() {
+		node, err = tx.getNode(node.childNodes[0])
+		if err != nil {
+			return err
+		}
+		height++
+	}
+	return tx.freeSubtree(pageNum, height)
+}
+
+func (tx *tx) freeSubtree(pageNum pgnum, height int) error {
+	if height > 0 {
+		node, err := tx.getNode(pageNum)
+		if err != nil {
+			return err
+		}
+		for _, child := range node.childNodes {
+			err = tx.freeSubtree(child, height-1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// A dirty copy of the page is dropped, so it isn't written on commit.
+	tx.touchNode(pageNum)
+	delete(tx.dirtyNodes, pageNum)
+	tx.pagesToDelete = append(tx.pagesToDelete, pageNum)
+	return nil
+}
@@ -0,0 +1,271 @@
+package LibraDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func rangeTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+// putRangeTestKeys puts n keys with big values, so the tree has a few levels.
+func putRangeTestKeys(t *testing.T, collection *Collection, n int) map[string]string {
+	expected := map[string]string{}
+	for _, i := range rand.New(rand.NewSource(int64(n))).Perm(n) {
+		key, value := rangeTestKey(i), fmt.Sprintf("%0100d", i)
+		require.NoError(t, collection.Put(key, []byte(value)))
+		expected[string(key)] = value
+	}
+	return expected
+}
+
+func requireCheckOK(t *testing.T, db *DB) *CheckReport {
+	report, err := db.Check()
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%v", report.Violations)
+	return report
+}
+
+func TestCollection_DeleteRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		db, cleanFunc := createTestDB(t)
+
+		n := 500 + r.Intn(1500)
+		tx := db.WriteTx()
+		collection, err := tx.CreateCollection(testCollectionName)
+		require.NoError(t, err)
+		expected := putRangeTestKeys(t, collection, n)
+		require.NoError(t, tx.Commit())
+
+		for i := 0; i < 3; i++ {
+			first, last := r.Intn(n+1), r.Intn(n+1)
+			if first > last {
+				first, last = last, first
+			}
+			start, end := rangeTestKey(first), rangeTestKey(last)
+			if r.Intn(5) == 0 {
+				start = nil
+			} else if r.Intn(5) == 0 {
+				end = nil
+			}
+
+			tx = db.WriteTx()
+			collection, err = tx.GetCollection(testCollectionName)
+			require.NoError(t, err)
+			require.NoError(t, collection.DeleteRange(start, end))
+			require.NoError(t, tx.Commit())
+
+			for key := range expected {
+				if (start == nil || key >= string(start)) && (end == nil || key < string(end)) {
+					delete(expected, key)
+				}
+			}
+			requireCollectionMatches(t, db, expected)
+			requireCheckOK(t, db)
+		}
+		cleanFunc()
+	}
+}
+
+func TestCollection_DeleteRangeReleasesPages(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	putRangeTestKeys(t, collection, 2000)
+	require.NoError(t, tx.Commit())
+	before := requireCheckOK(t, db)
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.DeleteRange(rangeTestKey(100), rangeTestKey(1900)))
+	require.NoError(t, tx.Commit())
+
+	after := requireCheckOK(t, db)
+	assert.Greater(t, after.FreePages, before.FreePages+before.ReachablePages/2)
+}
+
+func TestCollection_Truncate(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, collection.PutWithTTL(rangeTestKey(i), []byte(fmt.Sprintf("city%d:name", i%3)), time.Minute))
+	}
+	require.NoError(t, tx.Commit())
+	before := requireCheckOK(t, db)
+
+	watcher := db.Watch(testCollectionName, nil)
+	defer watcher.Close()
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Truncate())
+	requireCursorKeys(t, collection)
+	requireLookup(t, collection, "city", "city1")
+	require.NoError(t, collection.Put([]byte("a"), []byte("city1:name")))
+	require.NoError(t, tx.Commit())
+
+	for i := 0; i < 500; i++ {
+		change := <-watcher.Events
+		assert.Equal(t, ChangeDelete, change.Type)
+		assert.Equal(t, rangeTestKey(i), change.Key)
+	}
+	change := <-watcher.Events
+	assert.Equal(t, ChangePut, change.Type)
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireCursorKeys(t, collection, "a")
+	requireLookup(t, collection, "city", "city1", "a")
+	tx.Rollback()
+
+	// The expiry times were truncated as well
+	advance(time.Hour)
+	removed, err := db.RemoveExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	after := requireCheckOK(t, db)
+	assert.Greater(t, after.FreePages, before.FreePages)
+}
+
+func TestCollection_DeleteRangeKeyByKey(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, collection.Put(rangeTestKey(i), []byte(fmt.Sprintf("city%d:name", i%3))))
+	}
+	require.NoError(t, collection.DeleteRange(rangeTestKey(3), rangeTestKey(297)))
+	requireCursorKeys(t, collection, "key00000", "key00001", "key00002", "key00297", "key00298", "key00299")
+	requireLookup(t, collection, "city", "city0", "key00000", "key00297")
+	require.NoError(t, tx.Commit())
+
+	requireCheckOK(t, db)
+}
+
+func TestCollection_DeleteRangeRolledBack(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	expected := putRangeTestKeys(t, collection, 1000)
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	savepoint := tx.Savepoint()
+	require.NoError(t, collection.DeleteRange(rangeTestKey(10), nil))
+	require.NoError(t, tx.RollbackTo(savepoint))
+	require.NoError(t, collection.Truncate())
+	tx.Rollback()
+
+	requireCollectionMatches(t, db, expected)
+	requireCheckOK(t, db)
+}
+
+func TestTx_DeleteCollectionReleasesPages(t *testing.T) {
+	db, err := OpenStorage(NewMemoryStorage(), indexTestOptions(cityIndex))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, collection.Put(rangeTestKey(i), []byte(fmt.Sprintf("city%d:%0100d", i%3, i))))
+	}
+	require.NoError(t, tx.Commit())
+	before := requireCheckOK(t, db)
+
+	tx = db.WriteTx()
+	require.NoError(t, tx.DeleteCollection(testCollectionName))
+	require.NoError(t, tx.Commit())
+
+	after := requireCheckOK(t, db)
+	assert.Less(t, after.ReachablePages, before.ReachablePages/10)
+	assert.Greater(t, after.FreePages, before.FreePages+before.ReachablePages/2)
+
+	// The released pages are reused
+	tx = db.WriteTx()
+	collection, err = tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	var keys []string
+	for i := 0; i < 100; i++ {
+		require.NoError(t, collection.Put(rangeTestKey(i), []byte("value")))
+		keys = append(keys, string(rangeTestKey(i)))
+	}
+	require.NoError(t, tx.Commit())
+	sort.Strings(keys)
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	requireCursorKeys(t, collection, keys...)
+	tx.Rollback()
+	requireCheckOK(t, db)
+}
+
+func TestCollection_DeleteLargeRanges(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	// Each of the deletes below releases more pages than a single freelist page holds
+	for _, deleteFunc := range []func(tx *tx, collection *Collection) error{
+		func(tx *tx, collection *Collection) error {
+			return collection.Truncate()
+		},
+		func(tx *tx, collection *Collection) error {
+			return collection.DeleteRange(rangeTestKey(10), rangeTestKey(19990))
+		},
+		func(tx *tx, collection *Collection) error {
+			return tx.DeleteCollection(testCollectionName)
+		},
+	} {
+		tx := db.WriteTx()
+		collection, err := tx.CreateCollection(testCollectionName)
+		require.NoError(t, err)
+		putRangeTestKeys(t, collection, 20000)
+		require.NoError(t, tx.Commit())
+		before := requireCheckOK(t, db)
+		require.Greater(t, before.ReachablePages, pageCapacity(db.pageSize))
+
+		tx = db.WriteTx()
+		collection, err = tx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+		require.NoError(t, deleteFunc(tx, collection))
+		require.NoError(t, tx.Commit())
+
+		after := requireCheckOK(t, db)
+		assert.Greater(t, after.FreePages, before.FreePages+pageCapacity(db.pageSize))
+
+		tx = db.WriteTx()
+		require.NoError(t, tx.DeleteCollection(testCollectionName))
+		require.NoError(t, tx.Commit())
+	}
+}
@@ -0,0 +1,201 @@
+package LibraDB
+
+import "errors"
+
+var ErrInvalidSavepoint = errors.New("the savepoint doesn't belong to the transaction, or was released or discarded")
+
+// Savepoint marks a point inside a transaction that the transaction can be rolled back to without discarding the
+// changes made before it.
+type Savepoint struct {
+	tx    *tx
+	index int
+	state *txState
+}
+
+// txState is the state of a write transaction at a savepoint. Dirty nodes aren't copied when the savepoint is taken.
+// Instead, the first time a node is changed after it, the node as it was is kept in dirtyNodes, or nil if the page
+// wasn't dirty yet. The slices are only appended to, so they are kept as they were.
+type txState struct {
+	dirtyNodes        map[pgnum]*Node
+	pagesToDelete     []pgnum
+	allocatedPageNums []pgnum
+	dirtyCollections  map[string]*Collection
+	root              pgnum
+	changes           []*Change
+	onCommit          []func()
+	onRollback        []func()
+}
+
+// clone copies the node so later changes to the original don't affect it. Items are never modified in place, only
+// replaced, so they are shared.
+func (n *Node) clone() *Node {
+	return &Node{
+		tx:         n.tx,
+		pageNum:    n.pageNum,
+		items:      append([]*Item{}, n.items...),
+		childNodes: append([]pgnum{}, n.childNodes...),
+	}
+}
+
+// state returns the current state of the transaction. The slices are capped, so appending to them after the state was
+// taken doesn't write into it.
+func (tx *tx) state() *txState {
+	state := &txState{
+		dirtyNodes:        map[pgnum]*Node{},
+		pagesToDelete:     tx.pagesToDelete[:len(tx.pagesToDelete):len(tx.pagesToDelete)],
+		allocatedPageNums: tx.allocatedPageNums[:len(tx.allocatedPageNums):len(tx.allocatedPageNums)],
+		dirtyCollections:  make(map[string]*Collection, len(tx.dirtyCollections)),
+		root:              tx.root,
+		changes:           tx.changes[:len(tx.changes):len(tx.changes)],
+		onCommit:          tx.onCommit[:len(tx.onCommit):len(tx.onCommit)],
+		onRollback:        tx.onRollback[:len(tx.onRollback):len(tx.onRollback)],
+	}
+	for name, collection := range tx.dirtyCollections {
+		state.dirtyCollections[name] = collection
+	}
+	return state
+}
+
+// touchNode keeps the page as it was at the last savepoint, before it's changed for the first time since. A dirty node
+// is copied, so the one kept isn't changed, and the copy is returned. Otherwise, nil is returned.
+func (tx *tx) touchNode(pageNum pgnum) *Node {
+	if len(tx.savepoints) == 0 {
+		return nil
+	}
+	state := tx.savepoints[len(tx.savepoints)-1]
+	if _, ok := state.dirtyNodes[pageNum]; ok {
+		return nil
+	}
+
+	node, ok := tx.dirtyNodes[pageNum]
+	state.dirtyNodes[pageNum] = node
+	if !ok {
+		return nil
+	}
+	node = node.clone()
+	tx.dirtyNodes[pageNum] = node
+	return node
+}
+
+// Savepoint returns a savepoint at the current state of the transaction. Savepoints can be nested: rolling back to a
+// savepoint discards the savepoints taken after it, but the savepoint itself can be rolled back to again.
+func (tx *tx) Savepoint() *Savepoint {
+	if !tx.write {
+		return &Savepoint{tx, -1, nil}
+	}
+
+	state := tx.state()
+	tx.savepoints = append(tx.savepoints, state)
+	return &Savepoint{tx, len(tx.savepoints) - 1, state}
+}
+
+// checkSavepoint returns ErrInvalidSavepoint if the savepoint doesn't belong to the transaction, or it was released or
+// discarded by rolling back to a savepoint taken before it.
+func (tx *tx) checkSavepoint(sp *Savepoint) error {
+	if sp.tx != tx {
+		return ErrInvalidSavepoint
+	}
+	if tx.write && (sp.index < 0 || sp.index >= len(tx.savepoints) || tx.savepoints[sp.index] != sp.state) {
+		return ErrInvalidSavepoint
+	}
+	return nil
+}
+
+// ReleaseSavepoint discards the savepoint and the savepoints taken after it, keeping the changes made since. The
+// savepoints taken before it can still be rolled back to.
+func (tx *tx) ReleaseSavepoint(sp *Savepoint) error {
+	err := tx.checkActive()
+	if err != nil {
+		return err
+	}
+	err = tx.checkSavepoint(sp)
+	if err != nil || !tx.write {
+		return err
+	}
+
+	// The savepoint before keeps the nodes as they were when it was taken, so the ones changed only since the released
+	// savepoints are kept there.
+	if sp.index > 0 {
+		previous := tx.savepoints[sp.index-1]
+		for _, state := range tx.savepoints[sp.index:] {
+			for pageNum, node := range state.dirtyNodes {
+				if _, ok := previous.dirtyNodes[pageNum]; !ok {
+					previous.dirtyNodes[pageNum] = node
+				}
+			}
+		}
+	}
+	tx.savepoints = tx.savepoints[:sp.index]
+	return nil
+}
+
+// RollbackTo discards the changes made since the savepoint was taken. Pages allocated since are given back to the
+// freelist, the collections modified since get their roots back, and the changes reported to watchers
+// and the hooks registered since are dropped. Cursors opened before the call must not be used
+// after it.
+func (tx *tx) RollbackTo(sp *Savepoint) error {
+	err := tx.checkActive()
+	if err != nil {
+		return err
+	}
+	err = tx.checkSavepoint(sp)
+	if err != nil || !tx.write {
+		return err
+	}
+
+	// The nodes changed since are put back the way they were, newest savepoint first, so the savepoint's wins. The
+	// savepoint is kept empty, so it can be rolled back to more than once.
+	for i := len(tx.savepoints) - 1; i >= sp.index; i-- {
+		for pageNum, node := range tx.savepoints[i].dirtyNodes {
+			if node == nil {
+				delete(tx.dirtyNodes, pageNum)
+			} else {
+				tx.dirtyNodes[pageNum] = node
+			}
+		}
+	}
+	tx.savepoints = tx.savepoints[:sp.index+1]
+	state := sp.state
+	state.dirtyNodes = map[pgnum]*Node{}
+
+	for _, pageNum := range tx.allocatedPageNums[len(state.allocatedPageNums):] {
+		tx.db.releasePage(pageNum)
+	}
+	tx.pagesToDelete = state.pagesToDelete
+	tx.allocatedPageNums = state.allocatedPageNums
+	tx.dirtyCollections = make(map[string]*Collection, len(state.dirtyCollections))
+	for name, collection := range state.dirtyCollections {
+		tx.dirtyCollections[name] = collection
+	}
+	tx.root = state.root
+	tx.changes = state.changes
+	tx.onCommit = state.onCommit
+	tx.onRollback = state.onRollback
+	// Expiry collections created since are gone
+	tx.expiring = map[string]bool{}
+
+	// Collection handles hold their root page, sequence and merge operator, which may have changed since the savepoint.
+	// Handles of collections that didn't exist yet are dropped.
+	rootCollection := tx.getRootCollection()
+	for name, collection := range tx.collections {
+		item, err := rootCollection.Find([]byte(name))
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			delete(tx.collections, name)
+			continue
+		}
+		stored := newEmptyCollection()
+		stored.deserialize(item)
+		collection.root = stored.root
+		collection.counter = stored.counter
+		collection.mergeOperator = stored.mergeOperator
+	}
+	for name := range tx.dirtyCollections {
+		if collection, ok := tx.collections[name]; ok {
+			tx.dirtyCollections[name] = collection
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package LibraDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func savepointTestItem(i int) []byte {
+	return append(createItem("0")[:testValSize-3], fmt.Sprintf("%03d", i)...)
+}
+
+func TestTx_RollbackToSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		val := savepointTestItem(i)
+		require.NoError(t, collection.Put(val, val))
+	}
+
+	sp := tx.Savepoint()
+	allocated := len(tx.allocatedPageNums)
+
+	// Enough items to split the root, so the collection root changes after the savepoint
+	for i := 10; i < 40; i++ {
+		val := savepointTestItem(i)
+		require.NoError(t, collection.Put(val, val))
+	}
+	require.NoError(t, collection.Remove(savepointTestItem(0)))
+	other, err := tx.CreateCollection([]byte("other"))
+	require.NoError(t, err)
+	require.NoError(t, other.Put([]byte("key"), []byte("value")))
+
+	require.NoError(t, tx.RollbackTo(sp))
+	assert.Len(t, tx.allocatedPageNums, allocated)
+
+	// The same handle keeps working after the rollback
+	item, err := collection.Find(savepointTestItem(0))
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	item, err = collection.Find(savepointTestItem(10))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	val := savepointTestItem(100)
+	require.NoError(t, collection.Put(val, val))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	other, err = tx.GetCollection([]byte("other"))
+	require.NoError(t, err)
+	assert.Nil(t, other)
+
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	expected := map[string]string{}
+	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 100} {
+		expected[string(savepointTestItem(i))] = string(savepointTestItem(i))
+	}
+	actual := map[string]string{}
+	cursor := collection.Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil && err == nil; key, value, err = cursor.Next() {
+		actual[string(key)] = string(value)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+}
+
+func TestTx_NestedSavepoints(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	sp1 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("a"), []byte("a")))
+	sp2 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("b"), []byte("b")))
+
+	require.NoError(t, tx.RollbackTo(sp2))
+	item, err := collection.Find([]byte("b"))
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	item, err = collection.Find([]byte("a"))
+	require.NoError(t, err)
+	assert.NotNil(t, item)
+
+	// Rolling back to sp1 discards sp2, but sp1 itself can be used again
+	require.NoError(t, tx.RollbackTo(sp1))
+	assert.ErrorIs(t, tx.RollbackTo(sp2), ErrInvalidSavepoint)
+	require.NoError(t, collection.Put([]byte("c"), []byte("c")))
+	require.NoError(t, tx.RollbackTo(sp1))
+
+	for _, key := range []string{"a", "b", "c"} {
+		item, err = collection.Find([]byte(key))
+		require.NoError(t, err)
+		assert.Nil(t, item)
+	}
+
+	assert.ErrorIs(t, tx.RollbackTo(&Savepoint{}), ErrInvalidSavepoint)
+}
+
+func TestTx_SavepointCopiesChangedNodesOnly(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		val := savepointTestItem(i)
+		require.NoError(t, collection.Put(val, val))
+	}
+
+	sp := tx.Savepoint()
+	assert.Empty(t, sp.state.dirtyNodes)
+	val := savepointTestItem(100)
+	require.NoError(t, collection.Put(val, val))
+	// Only the nodes on the path to the new item are kept for the savepoint
+	assert.Less(t, len(sp.state.dirtyNodes), len(tx.dirtyNodes))
+
+	require.NoError(t, tx.RollbackTo(sp))
+	item, err := collection.Find(val)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	for i := 0; i < 40; i++ {
+		item, err = collection.Find(savepointTestItem(i))
+		require.NoError(t, err)
+		assert.NotNil(t, item)
+	}
+}
+
+func TestTx_RollbackToRefreshesHandlesOpenedAfterSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	defer tx.Rollback()
+	sp := tx.Savepoint()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	root := collection.root
+	for i := 0; i < 40; i++ {
+		val := savepointTestItem(i)
+		require.NoError(t, collection.Put(val, val))
+	}
+	created, err := tx.CreateCollection([]byte("created"))
+	require.NoError(t, err)
+	require.NoError(t, created.Put([]byte("key"), []byte("value")))
+
+	require.NoError(t, tx.RollbackTo(sp))
+	assert.Equal(t, root, collection.root)
+	requireCursorKeys(t, collection, "key")
+	created, err = tx.GetCollection([]byte("created"))
+	require.NoError(t, err)
+	assert.Nil(t, created)
+}
+
+func TestTx_ReleaseSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	sp1 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("a"), []byte("a")))
+	sp2 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("b"), []byte("b")))
+
+	// Releasing keeps the changes made since
+	require.NoError(t, tx.ReleaseSavepoint(sp2))
+	assert.ErrorIs(t, tx.RollbackTo(sp2), ErrInvalidSavepoint)
+	assert.ErrorIs(t, tx.ReleaseSavepoint(sp2), ErrInvalidSavepoint)
+	requireCursorKeys(t, collection, "a", "b")
+
+	// The savepoint taken before still rolls back the changes made after the released one
+	require.NoError(t, tx.RollbackTo(sp1))
+	requireCursorKeys(t, collection)
+}
+
+func TestTx_RollbackToDiscardedSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	sp1 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("a"), []byte("a")))
+	sp2 := tx.Savepoint()
+	require.NoError(t, tx.RollbackTo(sp1))
+
+	// A savepoint taken after the rollback doesn't revive the discarded one
+	require.NoError(t, collection.Put([]byte("b"), []byte("b")))
+	sp3 := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("c"), []byte("c")))
+	assert.ErrorIs(t, tx.RollbackTo(sp2), ErrInvalidSavepoint)
+	requireCursorKeys(t, collection, "b", "c")
+
+	require.NoError(t, tx.RollbackTo(sp3))
+	requireCursorKeys(t, collection, "b")
+}
@@ -0,0 +1,183 @@
+package LibraDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	ErrSequenceOverflow      = errors.New("the sequence can't go past the maximum uint64")
+	ErrInvalidReservation    = errors.New("at least one id must be reserved")
+	ErrInvalidCollectionName = errors.New("collection names can't start with a zero byte")
+	ErrInvalidSequenceName   = errors.New("the sequence name is too long")
+)
+
+// Entries of the root collection whose key starts with reservedKeyPrefix are internal and hidden from users. Named
+// sequences are stored under sequenceKeyPrefix, followed by their name, with their value as a uint64. Other reserved
+// entries are hidden collections, such as indexes.
+const (
+	reservedKeyPrefix = 0
+	sequenceKeyPrefix = "\x00sequence:"
+	sequenceSize      = 8
+
+	MaxSequenceNameSize = MaxKeySize - len(sequenceKeyPrefix)
+)
+
+// isReservedKey reports whether an entry of the root collection is internal.
+func isReservedKey(key []byte) bool {
+	return len(key) > 0 && key[0] == reservedKeyPrefix
+}
+
+// isCollectionKey reports whether an entry of the root collection holds a collection, either a user collection or a
+// hidden one.
+func isCollectionKey(key []byte) bool {
+	return !bytes.HasPrefix(key, []byte(sequenceKeyPrefix))
+}
+
+// reserve returns the first of n ids that follow current, and the new value of the sequence.
+func reserve(current uint64, n uint64) (uint64, uint64, error) {
+	if n == 0 {
+		return 0, 0, ErrInvalidReservation
+	}
+	if current > math.MaxUint64-n {
+		return 0, 0, ErrSequenceOverflow
+	}
+	return current + 1, current + n, nil
+}
+
+// Sequence returns the last id handed out by the sequence of the collection, 0 if none was.
+func (c *Collection) Sequence() uint64 {
+	return c.counter
+}
+
+// NextSequence increments the sequence of the collection and returns the new value, so the first id is 1. The
+// sequence is saved with the collection on commit, and goes back if the transaction is rolled back.
+func (c *Collection) NextSequence() (uint64, error) {
+	return c.ReserveSequence(1)
+}
+
+// ReserveSequence reserves a block of n ids at once and returns the first one. The ids up to first+n-1 aren't handed
+// out again, so they can be used without going through the sequence.
+func (c *Collection) ReserveSequence(n uint64) (uint64, error) {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return 0, err
+	}
+
+	first, last, err := reserve(c.counter, n)
+	if err != nil {
+		return 0, err
+	}
+	c.counter = last
+	return first, c.saveSequence()
+}
+
+// SetSequence sets the sequence of the collection, so the next id handed out is value+1.
+func (c *Collection) SetSequence(value uint64) error {
+	err := c.tx.checkWrite()
+	if err != nil {
+		return err
+	}
+
+	c.counter = value
+	return c.saveSequence()
+}
+
+// saveSequence writes the collection metadata back right away, so the sequence is committed even if the collection's
+// tree isn't modified.
+func (c *Collection) saveSequence() error {
+	c.tx.markDirty(c)
+	return c.tx.updateCollection(c)
+}
+
+func sequenceKey(name []byte) ([]byte, error) {
+	if len(name) > MaxSequenceNameSize {
+		return nil, ErrInvalidSequenceName
+	}
+	return append([]byte(sequenceKeyPrefix), name...), nil
+}
+
+// Sequence returns the value of the named sequence, 0 if it doesn't exist. Named sequences don't belong to a
+// collection and are stored in the root collection.
+func (tx *tx) Sequence(name []byte) (uint64, error) {
+	key, err := sequenceKey(name)
+	if err != nil {
+		return 0, err
+	}
+
+	item, err := tx.getRootCollection().Find(key)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		return 0, nil
+	}
+	if len(item.value) != sequenceSize {
+		return 0, corruptedPageErr
+	}
+	return binary.LittleEndian.Uint64(item.value), nil
+}
+
+// NextSequence increments the named sequence and returns the new value. A sequence that doesn't exist starts at 0, so
+// the first id is 1.
+func (tx *tx) NextSequence(name []byte) (uint64, error) {
+	return tx.ReserveSequence(name, 1)
+}
+
+// ReserveSequence reserves a block of n ids of the named sequence and returns the first one.
+func (tx *tx) ReserveSequence(name []byte, n uint64) (uint64, error) {
+	err := tx.checkWrite()
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := tx.Sequence(name)
+	if err != nil {
+		return 0, err
+	}
+	first, last, err := reserve(current, n)
+	if err != nil {
+		return 0, err
+	}
+	return first, tx.SetSequence(name, last)
+}
+
+// SetSequence sets the named sequence, creating it if it doesn't exist.
+func (tx *tx) SetSequence(name []byte, value uint64) error {
+	err := tx.checkWrite()
+	if err != nil {
+		return err
+	}
+	key, err := sequenceKey(name)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, sequenceSize)
+	binary.LittleEndian.PutUint64(b, value)
+	return tx.getRootCollection().Put(key, b)
+}
+
+// DeleteSequence deletes the named sequence.
+func (tx *tx) DeleteSequence(name []byte) error {
+	err := tx.checkWrite()
+	if err != nil {
+		return err
+	}
+	key, err := sequenceKey(name)
+	if err != nil {
+		return err
+	}
+
+	return tx.getRootCollection().Remove(key)
+}
+
+// checkCollectionName rejects names reserved for the internal entries of the root collection.
+func checkCollectionName(name []byte) error {
+	if isReservedKey(name) {
+		return ErrInvalidCollectionName
+	}
+	return nil
+}
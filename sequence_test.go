@@ -0,0 +1,205 @@
+package LibraDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestCollection_NextSequence(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := uint64(1); i <= 3; i++ {
+		id, err := collection.NextSequence()
+		require.NoError(t, err)
+		assert.Equal(t, i, id)
+	}
+	require.NoError(t, tx.Commit())
+
+	// The sequence is persisted even though the tree of the collection isn't modified
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), collection.Sequence())
+	id, err := collection.NextSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), id)
+	require.NoError(t, tx.Commit())
+
+	// Rolled back ids are handed out again
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	_, err = collection.NextSequence()
+	require.NoError(t, err)
+	tx.Rollback()
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), collection.Sequence())
+	_, err = collection.NextSequence()
+	assert.ErrorIs(t, err, writeInsideReadTxErr)
+	assert.Equal(t, uint64(0), collection.ID())
+}
+
+func TestCollection_ReserveAndSetSequence(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+
+	first, err := collection.ReserveSequence(100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	first, err = collection.ReserveSequence(10)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), first)
+	_, err = collection.ReserveSequence(0)
+	assert.ErrorIs(t, err, ErrInvalidReservation)
+
+	require.NoError(t, collection.SetSequence(1000))
+	id, err := collection.NextSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1001), id)
+
+	// ID keeps counting from the same sequence
+	assert.Equal(t, uint64(1001), collection.ID())
+
+	require.NoError(t, collection.SetSequence(math.MaxUint64-1))
+	_, err = collection.ReserveSequence(2)
+	assert.ErrorIs(t, err, ErrSequenceOverflow)
+	id, err = collection.NextSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), id)
+}
+
+func TestCollection_SequenceRollbackToSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	_, err = collection.NextSequence()
+	require.NoError(t, err)
+
+	sp := tx.Savepoint()
+	_, err = collection.ReserveSequence(10)
+	require.NoError(t, err)
+	require.NoError(t, tx.RollbackTo(sp))
+
+	id, err := collection.NextSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), id)
+}
+
+func TestCollection_SequenceSharedByHandles(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	c1, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	c2, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := uint64(1); i <= 4; i += 2 {
+		id, err := c1.NextSequence()
+		require.NoError(t, err)
+		assert.Equal(t, i, id)
+		id, err = c2.NextSequence()
+		require.NoError(t, err)
+		assert.Equal(t, i+1, id)
+	}
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), collection.Sequence())
+}
+
+func TestTx_NamedSequences(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := uint64(1); i <= 3; i++ {
+		id, err := tx.NextSequence([]byte("orders"))
+		require.NoError(t, err)
+		assert.Equal(t, i, id)
+	}
+	first, err := tx.ReserveSequence([]byte("invoices"), 50)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	require.NoError(t, tx.Commit())
+
+	tx = db.WriteTx()
+	value, err := tx.Sequence([]byte("orders"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), value)
+	value, err = tx.Sequence([]byte("invoices"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(50), value)
+	require.NoError(t, tx.SetSequence([]byte("orders"), 10))
+	id, err := tx.NextSequence([]byte("orders"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), id)
+	require.NoError(t, tx.DeleteSequence([]byte("invoices")))
+	value, err = tx.Sequence([]byte("invoices"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), value)
+
+	// Sequences aren't collections
+	collections, err := tx.Collections()
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	assert.Equal(t, testCollectionName, collections[0].Name())
+	require.NoError(t, tx.Commit())
+
+	report, err := db.Check()
+	require.NoError(t, err)
+	assert.Empty(t, report.Violations)
+	_, err = db.Compact()
+	require.NoError(t, err)
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	value, err = tx.Sequence([]byte("orders"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), value)
+}
+
+func TestTx_ReservedCollectionNames(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	defer tx.Rollback()
+	_, err := tx.CreateCollection([]byte("\x00name"))
+	assert.ErrorIs(t, err, ErrInvalidCollectionName)
+	_, err = tx.GetCollection([]byte(sequenceKeyPrefix + "orders"))
+	assert.ErrorIs(t, err, ErrInvalidCollectionName)
+	assert.ErrorIs(t, tx.DeleteCollection([]byte("\x00name")), ErrInvalidCollectionName)
+
+	_, err = tx.NextSequence(make([]byte, MaxSequenceNameSize+1))
+	assert.ErrorIs(t, err, ErrInvalidSequenceName)
+}
@@ -0,0 +1,128 @@
+package LibraDB
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrDatabaseLocked = errors.New("the database is locked by another process")
+
+// Storage is where the database pages are kept. dal reads and writes whole pages at page aligned offsets. The default
+// storage is a file on the disk, but any implementation can be used with OpenStorage.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+
+	// Sync makes all the writes so far durable.
+	Sync() error
+	Truncate(size int64) error
+	Size() (int64, error)
+	// Lock takes an exclusive lock on the storage, so it's not used by two databases at the same time. It returns
+	// ErrDatabaseLocked if the storage is already locked. The lock is released on Close.
+	Lock() error
+	Close() error
+}
+
+// fileStorage is the default storage, backed by a file on the disk.
+type fileStorage struct {
+	*os.File
+}
+
+func newFileStorage(path string) (*fileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStorage{file}, nil
+}
+
+func (s *fileStorage) Size() (int64, error) {
+	info, err := s.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *fileStorage) Lock() error {
+	return lockFile(s.File)
+}
+
+// MemoryStorage keeps the pages in memory. The data is kept after Close, so the same storage can be opened again.
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	data   []byte
+	locked bool
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *MemoryStorage) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(s.data)) {
+		s.data = append(s.data, make([]byte, end-int64(len(s.data)))...)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+func (s *MemoryStorage) Sync() error {
+	return nil
+}
+
+func (s *MemoryStorage) Truncate(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if size < int64(len(s.data)) {
+		s.data = s.data[:size]
+	} else {
+		s.data = append(s.data, make([]byte, size-int64(len(s.data)))...)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Size() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.data)), nil
+}
+
+func (s *MemoryStorage) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked {
+		return ErrDatabaseLocked
+	}
+	s.locked = true
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked = false
+	return nil
+}
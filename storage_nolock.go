@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package LibraDB
+
+import "os"
+
+// lockFile is a no-op on platforms without flock. Opening the same file from two processes isn't detected there.
+func lockFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package LibraDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	n, err := storage.WriteAt([]byte("world"), 6)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = storage.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+
+	size, err := storage.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+
+	buf := make([]byte, 11)
+	n, err = storage.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello\x00world"), buf[:n])
+
+	n, err = storage.ReadAt(buf, 6)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("world"), buf[:n])
+
+	require.NoError(t, storage.Truncate(5))
+	size, err = storage.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	_, err = storage.ReadAt(buf, 5)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMemoryStorage_Lock(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	db, err := OpenStorage(storage, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+
+	_, err = OpenStorage(storage, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+
+	// The failed open must not release the lock of the first database
+	assert.ErrorIs(t, storage.Lock(), ErrDatabaseLocked)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	// The data is kept after close, so the storage can be opened again
+	db, err = OpenStorage(storage, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx = db.ReadTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	item, err := collection.Find([]byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), item.value)
+	require.NoError(t, tx.Commit())
+}
+
+func TestFileStorage_Lock(t *testing.T) {
+	path := getTempFileName()
+	defer os.Remove(path)
+
+	db, err := Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+
+	_, err = Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(path, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
@@ -27,7 +27,7 @@ var (
 )
 
 func createTestDB(t *testing.T) (*DB, func()) {
-	db, err := Open(getTempFileName(), &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	db, err := OpenStorage(NewMemoryStorage(), &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
 	require.NoError(t, err)
 
 	return db, func() {
@@ -42,6 +42,11 @@ func areCollectionsEqual(t *testing.T, c1, c2 *Collection) {
 }
 
 func areTreesEqual(t *testing.T, t1, t2 *Collection) {
+	t1, closeFunc1 := openTestCollection(t, t1)
+	defer closeFunc1()
+	t2, closeFunc2 := openTestCollection(t, t2)
+	defer closeFunc2()
+
 	t1Root, err := t1.tx.getNode(t1.root)
 	require.NoError(t, err)
 
@@ -51,6 +56,20 @@ func areTreesEqual(t *testing.T, t1, t2 *Collection) {
 	areTreesEqualHelper(t, t1Root, t2Root)
 }
 
+// openTestCollection returns the collection as is if its transaction is still open. Otherwise, the collection is read
+// again in a new read transaction, which is closed by the returned function.
+func openTestCollection(t *testing.T, c *Collection) (*Collection, func()) {
+	if c.tx.status == txActive {
+		return c, func() {}
+	}
+
+	tx := c.tx.db.ReadTx()
+	collection, err := tx.GetCollection(c.name)
+	require.NoError(t, err)
+	require.NotNil(t, collection)
+	return collection, tx.Rollback
+}
+
 func areNodesEqual(t *testing.T, n1, n2 *Node) {
 	for i := 0; i < len(n1.items); i++ {
 		assert.Equal(t, n1.items[i].key, n2.items[i].key)
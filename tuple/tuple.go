@@ -0,0 +1,263 @@
+// Package tuple encodes tuples of key components into byte strings whose bytes.Compare order matches the order of
+// the tuples, which is how LibraDB orders keys. Tuples are compared element by element, and a tuple comes before the
+// longer tuples it's a prefix of. Elements of different types are ordered by type: nil, bytes, strings, integers,
+// floats and then booleans.
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidTuple = errors.New("the bytes aren't a packed tuple")
+
+// Tuple is a list of elements. Elements can be nil, []byte, string, bool, any integer type, float32 or float64.
+// Unpacked tuples hold integers as int64, or as uint64 if they don't fit, and floats as float64.
+type Tuple []interface{}
+
+// Type codes of the elements. Integers are encoded with a code per length, intZeroCode-n for negative integers of n
+// bytes and intZeroCode+n for positive ones.
+const (
+	nilCode     = 0x00
+	bytesCode   = 0x01
+	stringCode  = 0x02
+	intZeroCode = 0x14
+	floatCode   = 0x21
+	falseCode   = 0x26
+	trueCode    = 0x27
+
+	// Inside bytes and strings, 0x00 is written as 0x00 0xff, and a single 0x00 ends the element.
+	escapedNull = 0xff
+
+	// rangeEndCode is bigger than every type code, so it comes after every tuple with a given prefix.
+	rangeEndCode = 0xff
+)
+
+// Pack encodes the tuple.
+func (t Tuple) Pack() ([]byte, error) {
+	b := make([]byte, 0)
+	for i, element := range t {
+		var err error
+		b, err = appendElement(b, element)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return b, nil
+}
+
+// MustPack is like Pack but panics if the tuple has an element of an unsupported type.
+func (t Tuple) MustPack() []byte {
+	b, err := t.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Range returns the range of the keys of all the tuples that start with t, including t itself: start is inclusive and
+// end is exclusive.
+func (t Tuple) Range() (start []byte, end []byte, err error) {
+	start, err = t.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	end = append(append([]byte{}, start...), rangeEndCode)
+	return start, end, nil
+}
+
+func appendElement(b []byte, element interface{}) ([]byte, error) {
+	switch v := element.(type) {
+	case nil:
+		return append(b, nilCode), nil
+	case []byte:
+		return appendEscaped(append(b, bytesCode), v), nil
+	case string:
+		return appendEscaped(append(b, stringCode), []byte(v)), nil
+	case bool:
+		if v {
+			return append(b, trueCode), nil
+		}
+		return append(b, falseCode), nil
+	case int:
+		return appendInt(b, int64(v)), nil
+	case int8:
+		return appendInt(b, int64(v)), nil
+	case int16:
+		return appendInt(b, int64(v)), nil
+	case int32:
+		return appendInt(b, int64(v)), nil
+	case int64:
+		return appendInt(b, v), nil
+	case uint:
+		return appendUint(b, uint64(v)), nil
+	case uint8:
+		return appendUint(b, uint64(v)), nil
+	case uint16:
+		return appendUint(b, uint64(v)), nil
+	case uint32:
+		return appendUint(b, uint64(v)), nil
+	case uint64:
+		return appendUint(b, v), nil
+	case float32:
+		return appendFloat(b, float64(v)), nil
+	case float64:
+		return appendFloat(b, v), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", element)
+	}
+}
+
+func appendEscaped(b []byte, v []byte) []byte {
+	for _, x := range v {
+		b = append(b, x)
+		if x == 0x00 {
+			b = append(b, escapedNull)
+		}
+	}
+	return append(b, 0x00)
+}
+
+// bytesLen returns the number of bytes needed for v.
+func bytesLen(v uint64) int {
+	n := 0
+	for ; v > 0; v >>= 8 {
+		n++
+	}
+	return n
+}
+
+func appendBigEndian(b []byte, v uint64, n int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return append(b, buf[8-n:]...)
+}
+
+func appendUint(b []byte, v uint64) []byte {
+	n := bytesLen(v)
+	return appendBigEndian(append(b, byte(intZeroCode+n)), v, n)
+}
+
+// appendInt encodes negative integers with the ones' complement of their absolute value, so bigger absolute values
+// come first among integers of the same length.
+func appendInt(b []byte, v int64) []byte {
+	if v >= 0 {
+		return appendUint(b, uint64(v))
+	}
+
+	abs := uint64(-(v + 1)) + 1
+	n := bytesLen(abs)
+	mask := uint64(math.MaxUint64) >> (64 - 8*n)
+	return appendBigEndian(append(b, byte(intZeroCode-n)), ^abs&mask, n)
+}
+
+// appendFloat flips the sign bit of positive numbers and all the bits of negative ones, so the bytes sort in numeric
+// order.
+func appendFloat(b []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return appendBigEndian(append(b, floatCode), bits, 8)
+}
+
+// Unpack decodes a tuple encoded with Pack.
+func Unpack(b []byte) (Tuple, error) {
+	t := make(Tuple, 0)
+	for len(b) > 0 {
+		element, rest, err := decodeElement(b)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, element)
+		b = rest
+	}
+	return t, nil
+}
+
+func decodeElement(b []byte) (interface{}, []byte, error) {
+	code := b[0]
+	b = b[1:]
+	switch {
+	case code == nilCode:
+		return nil, b, nil
+	case code == bytesCode:
+		return decodeEscaped(b)
+	case code == stringCode:
+		v, rest, err := decodeEscaped(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(v), rest, nil
+	case code == falseCode:
+		return false, b, nil
+	case code == trueCode:
+		return true, b, nil
+	case code == floatCode:
+		if len(b) < 8 {
+			return nil, nil, ErrInvalidTuple
+		}
+		bits := binary.BigEndian.Uint64(b)
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), b[8:], nil
+	case code >= intZeroCode-8 && code <= intZeroCode+8:
+		return decodeInt(code, b)
+	default:
+		return nil, nil, ErrInvalidTuple
+	}
+}
+
+func decodeEscaped(b []byte) ([]byte, []byte, error) {
+	v := make([]byte, 0)
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			v = append(v, b[i])
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == escapedNull {
+			v = append(v, 0x00)
+			i++
+			continue
+		}
+		return v, b[i+1:], nil
+	}
+	return nil, nil, ErrInvalidTuple
+}
+
+func decodeInt(code byte, b []byte) (interface{}, []byte, error) {
+	negative := code < intZeroCode
+	n := int(code) - intZeroCode
+	if negative {
+		n = -n
+	}
+	if len(b) < n {
+		return nil, nil, ErrInvalidTuple
+	}
+
+	buf := make([]byte, 8)
+	copy(buf[8-n:], b[:n])
+	v := binary.BigEndian.Uint64(buf)
+	rest := b[n:]
+
+	if !negative {
+		if v > math.MaxInt64 {
+			return v, rest, nil
+		}
+		return int64(v), rest, nil
+	}
+
+	mask := uint64(math.MaxUint64) >> (64 - 8*n)
+	abs := ^v & mask
+	if abs > uint64(math.MaxInt64)+1 {
+		return nil, nil, ErrInvalidTuple
+	}
+	return -int64(abs-1) - 1, rest, nil
+}
@@ -0,0 +1,141 @@
+package tuple
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+// orderedTuples are in ascending order.
+var orderedTuples = []Tuple{
+	{},
+	{nil},
+	{nil, int64(1)},
+	{[]byte{}},
+	{[]byte{0x00}},
+	{[]byte{0x00, 0x00}},
+	{[]byte{0x00, 0x01}},
+	{[]byte{0x01}},
+	{[]byte{0xff}},
+	{""},
+	{"", nil},
+	{"a"},
+	{"a", nil},
+	{"a", "b"},
+	{"a", int64(0)},
+	{"a\x00"},
+	{"a\x00b"},
+	{"ab"},
+	{int64(math.MinInt64)},
+	{int64(-1 << 32)},
+	{int64(-256)},
+	{int64(-255)},
+	{int64(-2)},
+	{int64(-1)},
+	{int64(0)},
+	{int64(1)},
+	{int64(255)},
+	{int64(256)},
+	{int64(math.MaxInt64)},
+	{uint64(math.MaxInt64) + 1},
+	{uint64(math.MaxUint64)},
+	{math.Inf(-1)},
+	{-1e10},
+	{-1.5},
+	{math.Copysign(0, -1)},
+	{0.0},
+	{1.5},
+	{math.Inf(1)},
+	{false},
+	{true},
+	{true, nil},
+}
+
+func TestPack_Order(t *testing.T) {
+	for i := 1; i < len(orderedTuples); i++ {
+		a := orderedTuples[i-1].MustPack()
+		b := orderedTuples[i].MustPack()
+		assert.Equal(t, -1, bytes.Compare(a, b), "%#v should come before %#v", orderedTuples[i-1], orderedTuples[i])
+	}
+}
+
+func TestPack_RoundTrip(t *testing.T) {
+	for _, tuple := range orderedTuples {
+		unpacked, err := Unpack(tuple.MustPack())
+		require.NoError(t, err)
+		require.Equal(t, tuple, unpacked)
+	}
+
+	// Every integer type is unpacked as int64 and floats as float64
+	unpacked, err := Unpack(Tuple{int8(-5), uint16(7), 3, uint(9), float32(0.5)}.MustPack())
+	require.NoError(t, err)
+	assert.Equal(t, Tuple{int64(-5), int64(7), int64(3), int64(9), 0.5}, unpacked)
+}
+
+func TestPack_UnsupportedType(t *testing.T) {
+	_, err := Tuple{"a", struct{}{}}.Pack()
+	assert.Error(t, err)
+	assert.Panics(t, func() {
+		Tuple{map[string]string{}}.MustPack()
+	})
+}
+
+func TestUnpack_Invalid(t *testing.T) {
+	for _, b := range [][]byte{
+		{stringCode, 'a'},
+		{intZeroCode + 2, 0x01},
+		{floatCode, 0x00},
+		{0x50},
+	} {
+		_, err := Unpack(b)
+		assert.ErrorIs(t, err, ErrInvalidTuple)
+	}
+}
+
+func TestTuple_Range(t *testing.T) {
+	start, end, err := Tuple{"users", int64(7)}.Range()
+	require.NoError(t, err)
+
+	inside := []Tuple{
+		{"users", int64(7)},
+		{"users", int64(7), nil},
+		{"users", int64(7), "name"},
+		{"users", int64(7), true},
+	}
+	for _, tuple := range inside {
+		key := tuple.MustPack()
+		assert.True(t, bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0, "%#v should be in the range", tuple)
+	}
+
+	outside := []Tuple{
+		{"users"},
+		{"users", int64(6), "name"},
+		{"users", int64(8)},
+		{"users\x00", int64(7)},
+	}
+	for _, tuple := range outside {
+		key := tuple.MustPack()
+		assert.False(t, bytes.Compare(start, key) <= 0 && bytes.Compare(key, end) < 0, "%#v shouldn't be in the range", tuple)
+	}
+}
+
+func FuzzUnpack(f *testing.F) {
+	for _, tuple := range orderedTuples {
+		f.Add(tuple.MustPack())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tuple, err := Unpack(data)
+		if err != nil {
+			return
+		}
+
+		// Whatever can be unpacked is packed back to a tuple that unpacks and packs the same. The packed bytes are
+		// compared rather than the tuples, since NaNs aren't equal to themselves.
+		packed := tuple.MustPack()
+		unpacked, err := Unpack(packed)
+		require.NoError(t, err)
+		require.Equal(t, packed, unpacked.MustPack())
+	})
+}
@@ -1,5 +1,42 @@
 package LibraDB
 
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// TxLeak reports a transaction that was left open for longer than Options.TxLeakThreshold.
+type TxLeak struct {
+	Write    bool
+	OpenedAt time.Time
+	// Stack is the stack trace of the goroutine that opened the transaction.
+	Stack string
+}
+
+func (l *TxLeak) String() string {
+	kind := "read"
+	if l.Write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%s transaction opened at %s is still open after %s, opened by:\n%s", kind, l.OpenedAt.Format(time.RFC3339), time.Since(l.OpenedAt).Round(time.Millisecond), l.Stack)
+}
+
+func logTxLeak(leak *TxLeak) {
+	log.Print(leak)
+}
+
+type txStatus int
+
+const (
+	txActive txStatus = iota
+	txCommitted
+	txRolledBack
+)
+
 type tx struct {
 	dirtyNodes    map[pgnum]*Node
 	pagesToDelete []pgnum
@@ -7,19 +44,135 @@ type tx struct {
 	// new pages allocated during the transaction. They will be released if rollback is called.
 	allocatedPageNums []pgnum
 
-	write bool
+	// dirtyCollections holds the collections modified during the transaction by name. Their trees are written on
+	// commit, and their roots are updated to the new pages.
+	dirtyCollections map[string]*Collection
+
+	// collections holds the handle of every collection opened during the transaction by name. A collection has a
+	// single handle per transaction, so all its users see the same root page and sequence.
+	collections map[string]*Collection
+
+	// root is the page of the root collection as seen by the transaction. It's copied to the meta page on commit.
+	root pgnum
+
+	// savepoints holds the state of the transaction at every savepoint taken, oldest first.
+	savepoints []*txState
+
+	write  bool
+	status txStatus
+
+	// leakTimer reports the transaction if it's still open after Options.TxLeakThreshold.
+	leakTimer *time.Timer
+
+	// changes holds the changes made to collections, published to the watchers on commit.
+	changes []*Change
+
+	// onCommit and onRollback hold the hooks run once the outcome of the transaction is known.
+	onCommit   []func()
+	onRollback []func()
+
+	// expiring caches whether each collection has keys with a ttl by name, so collections without them don't look for
+	// their expiry times on every read and write.
+	expiring map[string]bool
+
+	// ctx is checked whenever a node is read and while committing, so canceling it stops the transaction's work.
+	ctx context.Context
 
 	db   *DB
 }
 
-func newTx(db *DB, write bool) *tx {
-	return &tx{
+func newTx(ctx context.Context, db *DB, write bool) *tx {
+	tx := &tx{
 		map[pgnum]*Node{},
 		make([]pgnum, 0),
 		make([]pgnum, 0),
+		map[string]*Collection{},
+		map[string]*Collection{},
+		db.root,
+		nil,
 		write,
+		txActive,
+		nil,
+		nil,
+		nil,
+		nil,
+		map[string]bool{},
+		ctx,
 		db,
 	}
+
+	if db.txLeakThreshold > 0 {
+		leak := &TxLeak{
+			Write:    write,
+			OpenedAt: time.Now(),
+			Stack:    string(debug.Stack()),
+		}
+		tx.leakTimer = time.AfterFunc(db.txLeakThreshold, func() {
+			db.txLeakHandler(leak)
+		})
+	}
+	return tx
+}
+
+// checkActive returns ErrTxClosed once the transaction was committed or rolled back.
+func (tx *tx) checkActive() error {
+	if tx.status != txActive {
+		return ErrTxClosed
+	}
+	return nil
+}
+
+// checkWrite checks the transaction can still be written to.
+func (tx *tx) checkWrite() error {
+	if tx.status != txActive {
+		return ErrTxClosed
+	}
+	if !tx.write {
+		return writeInsideReadTxErr
+	}
+	return nil
+}
+
+// close marks the transaction as done and releases the database lock. It must be called exactly once. The hooks of
+// the outcome run after the lock is released, so they can open transactions of their own.
+func (tx *tx) close(status txStatus) {
+	tx.status = status
+	if tx.leakTimer != nil {
+		tx.leakTimer.Stop()
+	}
+	if tx.write {
+		tx.db.rwlock.Unlock()
+	} else {
+		tx.db.rwlock.RUnlock()
+	}
+
+	hooks := tx.onRollback
+	if status == txCommitted {
+		hooks = tx.onCommit
+	}
+	tx.onCommit = nil
+	tx.onRollback = nil
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// OnCommit registers a function to run once the transaction is committed, after the commit is on the disk. Hooks run
+// in the order they were registered. They aren't run if the transaction is rolled back or its commit fails.
+func (tx *tx) OnCommit(hook func()) {
+	if tx.status != txActive {
+		return
+	}
+	tx.onCommit = append(tx.onCommit, hook)
+}
+
+// OnRollback registers a function to run once the transaction is rolled back, including when its commit fails. Hooks
+// run in the order they were registered.
+func (tx *tx) OnRollback(hook func()) {
+	if tx.status != txActive {
+		return
+	}
+	tx.onRollback = append(tx.onRollback, hook)
 }
 
 func (tx *tx) newNode(items []*Item, childNodes []pgnum) *Node {
@@ -34,6 +187,16 @@ func (tx *tx) newNode(items []*Item, childNodes []pgnum) *Node {
 }
 
 func (tx *tx) getNode(pageNum pgnum) (*Node, error) {
+	if tx.status != txActive {
+		return nil, ErrTxClosed
+	}
+	err := tx.ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	if node := tx.touchNode(pageNum); node != nil {
+		return node, nil
+	}
 	if node, ok := tx.dirtyNodes[pageNum]; ok {
 		return node, nil
 	}
@@ -47,6 +210,7 @@ func (tx *tx) getNode(pageNum pgnum) (*Node, error) {
 }
 
 func (tx *tx) writeNode(node *Node) *Node {
+	tx.touchNode(node.pageNum)
 	tx.dirtyNodes[node.pageNum] = node
 	node.tx = tx
 	return node
@@ -56,9 +220,14 @@ func (tx *tx) deleteNode(node *Node) {
 	tx.pagesToDelete = append(tx.pagesToDelete, node.pageNum)
 }
 
+// Rollback discards the transaction. Calling it on a transaction that was already committed or rolled back does
+// nothing, so it can be deferred right after the transaction is opened.
 func (tx *tx) Rollback() {
+	if tx.status != txActive {
+		return
+	}
 	if !tx.write {
-		tx.db.rwlock.RUnlock()
+		tx.close(txRolledBack)
 		return
 	}
 
@@ -68,86 +237,226 @@ func (tx *tx) Rollback() {
 		tx.db.freelist.releasePage(pageNum)
 	}
 	tx.allocatedPageNums = nil
-	tx.db.rwlock.Unlock()
+	tx.savepoints = nil
+	tx.changes = nil
+	tx.close(txRolledBack)
 }
 
+// Commit writes the changes of a write transaction to the disk. ErrTxClosed is returned if the transaction was
+// already committed or rolled back. If the context of the transaction is done before the commit takes effect, the
+// transaction is rolled back and the context's error is returned.
 func (tx *tx) Commit() error {
+	if tx.status != txActive {
+		return ErrTxClosed
+	}
 	if !tx.write {
-		tx.db.rwlock.RUnlock()
+		tx.close(txCommitted)
 		return nil
 	}
 
-	for _, node := range tx.dirtyNodes {
-		_, err := tx.db.writeNode(node)
+	err := tx.commit()
+	if err != nil {
+		// The pages of the last committed state are never overwritten, so reloading the meta and the freelist undoes
+		// everything the failed commit did.
+		_ = tx.db.reload()
+	} else {
+		tx.db.publish(tx.changes, tx.db.txid)
+	}
+
+	tx.dirtyNodes = nil
+	tx.pagesToDelete = nil
+	tx.allocatedPageNums = nil
+	tx.dirtyCollections = nil
+	tx.savepoints = nil
+	tx.changes = nil
+	if err != nil {
+		tx.close(txRolledBack)
+	} else {
+		tx.close(txCommitted)
+	}
+	return err
+}
+
+// commit uses COW (copy on write) to make commits atomic. The dirty nodes of every modified collection are written in
+// post order, and nodes that existed before the transaction are written to new pages instead of being overwritten.
+// The new page numbers are assigned to the parents, and so on until the collection root, whose new page is updated in
+// the root collection. Then the root collection is written the same way, followed by the freelist. Finally, the meta
+// page is written, so the new root collection takes effect.
+// New pages can't be seen until the meta page is written. This way, in case of a failure, no harm is done as nothing
+// was committed to the database.
+func (tx *tx) commit() error {
+	names := make([]string, 0, len(tx.dirtyCollections))
+	for name := range tx.dirtyCollections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	allocated := tx.allocatedPages()
+	for _, name := range names {
+		stored, err := tx.getCollection([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		// The collection was deleted during the transaction
+		if stored == nil {
+			continue
+		}
+		collection := tx.dirtyCollections[name]
+
+		root, ok := tx.dirtyNodes[collection.root]
+		if !ok {
+			continue
+		}
+		collection.root, err = tx.commitNode(root, allocated)
+		if err != nil {
+			return err
+		}
+		err = tx.updateCollection(collection)
+		if err != nil {
+			return err
+		}
+	}
+
+	if root, ok := tx.dirtyNodes[tx.root]; ok {
+		var err error
+		tx.root, err = tx.commitNode(root, tx.allocatedPages())
 		if err != nil {
 			return err
 		}
 	}
 
+	// Nodes that are still dirty aren't reachable anymore, for example nodes of a collection deleted in the
+	// transaction. Pages allocated for them are released.
+	deleted := make(map[pgnum]bool, len(tx.pagesToDelete))
+	for _, pageNum := range tx.pagesToDelete {
+		deleted[pageNum] = true
+	}
+	allocated = tx.allocatedPages()
+	for pageNum := range tx.dirtyNodes {
+		if allocated[pageNum] && !deleted[pageNum] {
+			tx.pagesToDelete = append(tx.pagesToDelete, pageNum)
+		}
+	}
+
+	// The freelist is written to new pages as well, as the current ones are still used by the last committed state.
+	tx.pagesToDelete = append(tx.pagesToDelete, tx.db.freelistPages()...)
+	tx.db.allocateFreelist(len(tx.pagesToDelete))
 	for _, pageNum := range tx.pagesToDelete {
 		tx.db.deleteNode(pageNum)
 	}
-	_, err := tx.db.writeFreelist()
+	err := tx.db.writeFreelist()
 	if err != nil {
 		return err
 	}
 
-	tx.dirtyNodes = nil
-	tx.pagesToDelete = nil
-	tx.allocatedPageNums = nil
-	tx.db.rwlock.Unlock()
-	return nil
+	// Everything must be on the disk before the meta page points to it.
+	err = tx.db.sync()
+	if err != nil {
+		return err
+	}
+
+	// This is the last point the commit can be given up without taking effect.
+	err = tx.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	tx.db.root = tx.root
+	tx.db.txid += 1
+	_, err = tx.db.writeMeta(tx.db.meta)
+	if err != nil {
+		return err
+	}
+	return tx.db.sync()
 }
 
+// commitNode writes a dirty node and its dirty descendants in post order, and returns the page the node was written
+// to. Post order is used since a parent can be written only after its children were given their new pages. Nodes
+// allocated during the transaction aren't part of the last committed state, so they are written in place.
+func (tx *tx) commitNode(node *Node, allocated map[pgnum]bool) (pgnum, error) {
+	err := tx.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
 
-// This will be used for implementing COW. The idea is to mark all the dirty collection, then for each collection,
-// traverse it's dirty in post order and commit child page. Then take the new page numbers, assign them to the parent,
-// and save him as well and so. After we wrote all collections, rewrite the root node with the new collections roots.
-// Rewrite the freelist with the newly allocated pages.  Finally, rewrite the meta page, so the new root node will take
-// effect.
-// COW will give us atomicity as new pages cannot be seen until the root node is written. This way, in case of a failure
-// or a rollback no harm will be done as nothing was committed to the database.
-//func (tx *tx) commitNode(node *Node) error {
-//	oldPageNum := node.num
-//	node.num = 0
-//
-//	newNode, err := tx.db.writeNode(node)
-//	if err != nil {
-//		return err
-//	}
-//	tx.committedNodes[oldPageNum] = newNode.num
-//	tx.deleteNode(node)
-//	return nil
-//}
-//
-//// saveDirtyNodes saves the tree in a post order way. post order is used since child pages are written to the disk and
-//// are given new page id, only then we can update the parent node with new page of the child node.
-//func (tx *tx) saveDirtyNodes(node *Node) error {
-//	if len(node.childNodes) == 0 {
-//		return tx.commitNode(node)
-//	}
-//
-//	for i, childNodePgid := range node.childNodes {
-//		if childNode, ok := tx.dirtyNodes[childNodePgid]; ok {
-//			err := tx.saveDirtyNodes(childNode)
-//			if err != nil {
-//				return err
-//			}
-//		}
-//		node.childNodes[i] = tx.committedNodes[childNodePgid]
-//	}
-//
-//	return tx.commitNode(node)
-//}
+	for i, childPageNum := range node.childNodes {
+		childNode, ok := tx.dirtyNodes[childPageNum]
+		if !ok {
+			continue
+		}
+
+		newChildPageNum, err := tx.commitNode(childNode, allocated)
+		if err != nil {
+			return 0, err
+		}
+		node.childNodes[i] = newChildPageNum
+	}
+
+	delete(tx.dirtyNodes, node.pageNum)
+	if !allocated[node.pageNum] {
+		tx.pagesToDelete = append(tx.pagesToDelete, node.pageNum)
+		node.pageNum = tx.db.getNextPage()
+	}
+
+	_, err = tx.db.writeNode(node)
+	if err != nil {
+		return 0, err
+	}
+	return node.pageNum, nil
+}
+
+func (tx *tx) allocatedPages() map[pgnum]bool {
+	allocated := make(map[pgnum]bool, len(tx.allocatedPageNums))
+	for _, pageNum := range tx.allocatedPageNums {
+		allocated[pageNum] = true
+	}
+	return allocated
+}
+
+// markDirty marks the given nodes as dirty and the collection as modified, so it's written on commit. It's called
+// with the path from the collection root to the modified nodes, so every dirty node can be reached from the root
+// through dirty nodes.
+func (tx *tx) markDirty(collection *Collection, nodes ...*Node) {
+	for _, node := range nodes {
+		tx.writeNode(node)
+	}
+	if !collection.isRoot {
+		tx.dirtyCollections[string(collection.name)] = collection
+	}
+}
 
 func (tx *tx) getRootCollection() *Collection {
 	rootCollection := newEmptyCollection()
-	rootCollection.root = tx.db.root
+	rootCollection.root = tx.root
+	rootCollection.isRoot = true
 	rootCollection.tx = tx
 	return rootCollection
 }
 
+// updateCollection writes the collection metadata back after its root page has changed. The root collection has no
+// entry of its own, so its new root is kept in the transaction and written to the meta page on commit.
+func (tx *tx) updateCollection(collection *Collection) error {
+	if collection.isRoot {
+		tx.root = collection.root
+		return nil
+	}
+
+	collectionBytes := collection.serialize()
+	rootCollection := tx.getRootCollection()
+	return rootCollection.Put(collection.name, collectionBytes.value)
+}
+
 func (tx *tx) GetCollection(name []byte) (*Collection, error) {
+	err := checkCollectionName(name)
+	if err != nil {
+		return nil, err
+	}
+	return tx.getCollection(name)
+}
+
+// getCollection returns the collection with the given name, including hidden collections.
+func (tx *tx) getCollection(name []byte) (*Collection, error) {
 	rootCollection := tx.getRootCollection()
 	item, err := rootCollection.Find(name)
 	if err != nil {
@@ -157,42 +466,97 @@ func (tx *tx) GetCollection(name []byte) (*Collection, error) {
 	if item == nil {
 		return nil, nil
 	}
+	return tx.openCollection(item)
+}
+
+// openCollection returns the handle of the collection stored in the given item of the root collection, creating it the
+// first time the collection is opened in the transaction.
+func (tx *tx) openCollection(item *Item) (*Collection, error) {
+	if collection, ok := tx.collections[string(item.key)]; ok {
+		return collection, nil
+	}
 
 	collection := newEmptyCollection()
 	collection.deserialize(item)
 	collection.tx = tx
+	err := collection.checkComparator()
+	if err != nil {
+		return nil, err
+	}
+	tx.collections[string(collection.name)] = collection
 	return collection, nil
 }
 
-func (tx *tx) CreateCollection(name []byte) (*Collection, error) {
-	if !tx.write {
-		return nil, writeInsideReadTxErr
+// Collections returns all the collections in the database ordered by name.
+func (tx *tx) Collections() ([]*Collection, error) {
+	collections := make([]*Collection, 0)
+	cursor := tx.getRootCollection().Cursor()
+	key, value, err := cursor.First()
+	for ; key != nil && err == nil; key, value, err = cursor.Next() {
+		if isReservedKey(key) {
+			continue
+		}
+		var collection *Collection
+		collection, err = tx.openCollection(newItem(key, value))
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
 	}
-
-	newCollectionPage, err := tx.db.writeNode(NewEmptyNode())
 	if err != nil {
 		return nil, err
 	}
+	return collections, nil
+}
 
-	newCollection := newEmptyCollection()
-	newCollection.name = name
-	newCollection.root = newCollectionPage.pageNum
-	return tx.createCollection(newCollection)
+func (tx *tx) CreateCollection(name []byte) (*Collection, error) {
+	return tx.CreateCollectionWithOptions(name, &CollectionOptions{})
 }
 
 func (tx *tx) DeleteCollection(name []byte) error {
-	if !tx.write {
-		return writeInsideReadTxErr
+	err := tx.checkWrite()
+	if err != nil {
+		return err
+	}
+	err = checkCollectionName(name)
+	if err != nil {
+		return err
 	}
 
 	rootCollection := tx.getRootCollection()
 
-	return rootCollection.Remove(name)
+	// The hidden collections holding the index entries and expiry times of the collection are deleted with it. The
+	// pages of all of them are released on commit.
+	delete(tx.expiring, string(name))
+	names := append(tx.hiddenCollectionNames(name), name)
+	for _, name := range names {
+		item, err := rootCollection.Find(name)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+
+		collection := newEmptyCollection()
+		collection.deserialize(item)
+		err = tx.freeTree(collection.root)
+		if err != nil {
+			return err
+		}
+		err = rootCollection.Remove(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 
 }
 
 func (tx *tx) createCollection(collection *Collection) (*Collection, error) {
 	collection.tx = tx
+	tx.dirtyCollections[string(collection.name)] = collection
+	tx.collections[string(collection.name)] = collection
 	collectionBytes := collection.serialize()
 
 	rootCollection := tx.getRootCollection()
@@ -1,10 +1,13 @@
 package LibraDB
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestTx_CreateCollection(t *testing.T) {
@@ -128,7 +131,9 @@ func TestTx_Rollback(t *testing.T) {
 	err = tx.Commit()
 	require.NoError(t, err)
 
-	assert.Len(t, tx.db.freelist.releasedPages, 0)
+	// The pages of the previous root collection and freelist are released, since they were copied on write.
+	releasedPages := append([]pgnum{}, tx.db.freelist.releasedPages...)
+	maxPage := tx.db.freelist.maxPage
 
 	// Try to add 9 but then perform a rollback, so it won't be saved
 	tx2 := db.WriteTx()
@@ -142,9 +147,11 @@ func TestTx_Rollback(t *testing.T) {
 
 	tx2.Rollback()
 
-	// 9 should not exist since a rollback was performed. A new page should be added to released page ids though, since
-	// a split occurred and a new page node was created, but later deleted.
-	assert.Len(t, tx2.db.freelist.releasedPages, 1)
+	// 9 should not exist since a rollback was performed. The pages allocated by the split are given back, so the
+	// freelist holds the same pages plus the ones the file grew by.
+	grownBy := int(tx2.db.freelist.maxPage - maxPage)
+	assert.Len(t, tx2.db.freelist.releasedPages, len(releasedPages)+grownBy)
+	assert.Subset(t, tx2.db.freelist.releasedPages, releasedPages)
 	tx3 := db.ReadTx()
 
 	collection, err = tx3.GetCollection(collection.name)
@@ -159,5 +166,283 @@ func TestTx_Rollback(t *testing.T) {
 	err = tx3.Commit()
 	require.NoError(t, err)
 
-	assert.Len(t, tx3.db.freelist.releasedPages, 1)
+	assert.Len(t, tx3.db.freelist.releasedPages, len(releasedPages)+grownBy)
+}
+
+func TestTx_CommitTwice(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	require.NoError(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), ErrTxClosed)
+	// Rolling back a closed transaction does nothing, so it doesn't unlock the database again
+	tx.Rollback()
+
+	readTx := db.ReadTx()
+	require.NoError(t, readTx.Commit())
+	assert.ErrorIs(t, readTx.Commit(), ErrTxClosed)
+	readTx.Rollback()
+
+	tx = db.WriteTx()
+	tx.Rollback()
+	assert.ErrorIs(t, tx.Commit(), ErrTxClosed)
+
+	// The lock was released exactly once by each transaction
+	tx = db.WriteTx()
+	require.NoError(t, tx.Commit())
+}
+
+func TestTx_UseAfterCommit(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tx.Commit())
+
+	assert.ErrorIs(t, collection.Put([]byte("key"), []byte("value")), ErrTxClosed)
+	assert.ErrorIs(t, collection.Remove([]byte("key")), ErrTxClosed)
+	_, err = collection.Find([]byte("key"))
+	assert.ErrorIs(t, err, ErrTxClosed)
+	_, _, err = collection.Cursor().First()
+	assert.ErrorIs(t, err, ErrTxClosed)
+	_, err = tx.GetCollection(testCollectionName)
+	assert.ErrorIs(t, err, ErrTxClosed)
+	_, err = tx.CreateCollection([]byte("other"))
+	assert.ErrorIs(t, err, ErrTxClosed)
+	assert.ErrorIs(t, tx.DeleteCollection(testCollectionName), ErrTxClosed)
+	assert.ErrorIs(t, tx.RollbackTo(tx.Savepoint()), ErrTxClosed)
+}
+
+func TestTx_LeakReport(t *testing.T) {
+	leaks := make(chan *TxLeak, 1)
+	db, err := OpenStorage(NewMemoryStorage(), &Options{
+		MinFillPercent:  testMinPercentage,
+		MaxFillPercent:  testMaxPercentage,
+		TxLeakThreshold: 10 * time.Millisecond,
+		TxLeakHandler: func(leak *TxLeak) {
+			leaks <- leak
+		},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Transactions closed in time aren't reported
+	require.NoError(t, db.ReadTx().Commit())
+
+	tx := db.WriteTx()
+	leak := <-leaks
+	tx.Rollback()
+
+	assert.True(t, leak.Write)
+	assert.Contains(t, leak.Stack, "TestTx_LeakReport")
+	assert.Contains(t, leak.String(), "write transaction opened at")
+
+	select {
+	case leak = <-leaks:
+		assert.Fail(t, "unexpected report", "%s", leak)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDB_BeginTxLockTimeout(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	holdingTx := db.WriteTx()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := db.BeginTx(ctx, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = db.BeginTx(ctx, &TxOptions{ReadOnly: true})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	holdingTx.Rollback()
+
+	// The locks acquired after giving up are released
+	tx, err := db.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	readTx, err := db.BeginTx(context.Background(), &TxOptions{ReadOnly: true})
+	require.NoError(t, err)
+	assert.False(t, readTx.write)
+	require.NoError(t, readTx.Commit())
+}
+
+func TestTx_ContextCanceledDuringScan(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, collection.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value")))
+	}
+	require.NoError(t, tx.Commit())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	readTx, err := db.BeginTx(ctx, &TxOptions{ReadOnly: true})
+	require.NoError(t, err)
+	defer readTx.Rollback()
+	collection, err = readTx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+
+	cursor := collection.Cursor()
+	key, _, err := cursor.First()
+	require.NoError(t, err)
+	require.NotNil(t, key)
+
+	cancel()
+	_, _, err = cursor.Next()
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = collection.Find([]byte("key050"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTx_ContextCanceledBeforeCommit(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key"), []byte("value")))
+
+	cancel()
+	assert.ErrorIs(t, tx.Commit(), context.Canceled)
+
+	// The transaction was rolled back
+	readTx := db.ReadTx()
+	defer readTx.Rollback()
+	collection, err = readTx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	assert.Nil(t, collection)
+}
+
+func TestTx_OnCommit(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	var calls []string
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key"), []byte("value")))
+	tx.OnCommit(func() {
+		// The lock is released and the data is committed by the time the hook runs
+		readTx := db.ReadTx()
+		defer readTx.Rollback()
+		collection, err := readTx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+		item, err := collection.Find([]byte("key"))
+		require.NoError(t, err)
+		calls = append(calls, string(item.value))
+	})
+	tx.OnCommit(func() {
+		calls = append(calls, "second")
+	})
+	tx.OnRollback(func() {
+		calls = append(calls, "rollback")
+	})
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []string{"value", "second"}, calls)
+
+	// Hooks run once
+	tx.Rollback()
+	assert.Equal(t, []string{"value", "second"}, calls)
+}
+
+func TestTx_OnRollback(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	var calls []string
+	tx := db.WriteTx()
+	tx.OnCommit(func() {
+		calls = append(calls, "commit")
+	})
+	tx.OnRollback(func() {
+		calls = append(calls, "rollback")
+	})
+	tx.Rollback()
+	assert.Equal(t, []string{"rollback"}, calls)
+
+	// A failed commit rolls back the transaction
+	calls = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	tx.OnCommit(func() {
+		calls = append(calls, "commit")
+	})
+	tx.OnRollback(func() {
+		calls = append(calls, "rollback")
+	})
+	cancel()
+	assert.ErrorIs(t, tx.Commit(), context.Canceled)
+	assert.Equal(t, []string{"rollback"}, calls)
+}
+
+func TestTx_HooksRolledBackToSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	var calls []string
+	tx := db.WriteTx()
+	tx.OnCommit(func() {
+		calls = append(calls, "before")
+	})
+	sp := tx.Savepoint()
+	tx.OnCommit(func() {
+		calls = append(calls, "after")
+	})
+	require.NoError(t, tx.RollbackTo(sp))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, []string{"before"}, calls)
+}
+
+func TestTx_CollectionHandlesShareTree(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// c2 is opened before c1 splits the root, and is written through last
+	tx = db.WriteTx()
+	c1, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	c2, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		require.NoError(t, c1.Put(key, key))
+	}
+	require.NoError(t, c2.Put([]byte("key200"), []byte("key200")))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err := tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	n := 0
+	cursor := collection.Cursor()
+	key, _, err := cursor.First()
+	for ; key != nil && err == nil; key, _, err = cursor.Next() {
+		n++
+	}
+	require.NoError(t, err)
+	assert.Equal(t, 201, n)
 }
@@ -0,0 +1,378 @@
+package LibraDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"github.com/amit-davidson/LibraDB/tuple"
+	"math"
+	"time"
+)
+
+var ErrInvalidEncoding = errors.New("the bytes weren't encoded by the codec")
+
+// Codec converts values of a type to bytes and back. Codecs used for keys must be order-preserving: bytes.Compare of
+// two encoded keys must match the order of the keys themselves, so cursors return typed keys in order.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// Order-preserving codecs, meant for keys.
+var (
+	// Uint64Codec encodes integers in big endian.
+	Uint64Codec Codec[uint64] = uint64Codec{}
+	// Int64Codec encodes integers in big endian with the sign bit flipped, so negative integers come first.
+	Int64Codec Codec[int64] = int64Codec{}
+	// Float64Codec flips the sign bit of positive numbers and all the bits of negative ones, so the bytes sort in
+	// numeric order. NaNs have no meaningful position.
+	Float64Codec Codec[float64] = float64Codec{}
+	StringCodec  Codec[string]  = stringCodec{}
+	BytesCodec   Codec[[]byte]  = bytesCodec{}
+	// TimeCodec encodes the seconds and nanoseconds since the epoch. The location isn't kept, times are decoded in UTC.
+	TimeCodec Codec[time.Time] = timeCodec{}
+	// TupleCodec packs tuples with the tuple package, for composite keys of mixed types.
+	TupleCodec Codec[tuple.Tuple] = tupleCodec{}
+)
+
+type uint64Codec struct{}
+
+func (uint64Codec) Encode(v uint64) ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b, nil
+}
+
+func (uint64Codec) Decode(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Encode(v int64) ([]byte, error) {
+	return uint64Codec{}.Encode(uint64(v) ^ (1 << 63))
+}
+
+func (int64Codec) Decode(b []byte) (int64, error) {
+	v, err := uint64Codec{}.Decode(b)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v ^ (1 << 63)), nil
+}
+
+type float64Codec struct{}
+
+func (float64Codec) Encode(v float64) ([]byte, error) {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return uint64Codec{}.Encode(bits)
+}
+
+func (float64Codec) Decode(b []byte) (float64, error) {
+	bits, err := uint64Codec{}.Decode(b)
+	if err != nil {
+		return 0, err
+	}
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) Decode(b []byte) (string, error) {
+	return string(b), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) Decode(b []byte) ([]byte, error) {
+	return append([]byte{}, b...), nil
+}
+
+type timeCodec struct{}
+
+func (timeCodec) Encode(v time.Time) ([]byte, error) {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b, uint64(v.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(b[8:], uint32(v.Nanosecond()))
+	return b, nil
+}
+
+func (timeCodec) Decode(b []byte) (time.Time, error) {
+	if len(b) != 12 {
+		return time.Time{}, ErrInvalidEncoding
+	}
+	seconds := int64(binary.BigEndian.Uint64(b) ^ (1 << 63))
+	nanos := binary.BigEndian.Uint32(b[8:])
+	if nanos >= uint32(time.Second) {
+		return time.Time{}, ErrInvalidEncoding
+	}
+	return time.Unix(seconds, int64(nanos)).UTC(), nil
+}
+
+// Pair is a composite key of two components, ordered by the first component and then by the second.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// PairCodec returns an order-preserving codec of pairs from the order-preserving codecs of their components. A pair is
+// packed as a tuple of its two encoded components, so a shorter first component comes before a longer one it's a
+// prefix of. Pairs can be nested in the second component to build longer composite keys.
+func PairCodec[A, B any](first Codec[A], second Codec[B]) Codec[Pair[A, B]] {
+	return pairCodec[A, B]{first, second}
+}
+
+type pairCodec[A, B any] struct {
+	first  Codec[A]
+	second Codec[B]
+}
+
+func (c pairCodec[A, B]) Encode(v Pair[A, B]) ([]byte, error) {
+	first, err := c.first.Encode(v.First)
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.second.Encode(v.Second)
+	if err != nil {
+		return nil, err
+	}
+	return tuple.Tuple{first, second}.Pack()
+}
+
+func (c pairCodec[A, B]) Decode(b []byte) (Pair[A, B], error) {
+	var v Pair[A, B]
+
+	t, err := tuple.Unpack(b)
+	if err != nil || len(t) != 2 {
+		return v, ErrInvalidEncoding
+	}
+	first, ok := t[0].([]byte)
+	if !ok {
+		return v, ErrInvalidEncoding
+	}
+	second, ok := t[1].([]byte)
+	if !ok {
+		return v, ErrInvalidEncoding
+	}
+
+	v.First, err = c.first.Decode(first)
+	if err != nil {
+		return v, err
+	}
+	v.Second, err = c.second.Decode(second)
+	if err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+type tupleCodec struct{}
+
+func (tupleCodec) Encode(v tuple.Tuple) ([]byte, error) {
+	return v.Pack()
+}
+
+func (tupleCodec) Decode(b []byte) (tuple.Tuple, error) {
+	t, err := tuple.Unpack(b)
+	if err != nil {
+		return nil, ErrInvalidEncoding
+	}
+	return t, nil
+}
+
+// JSONCodec returns a codec that encodes values as JSON. It isn't order-preserving, so it's meant for values.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// GobCodec returns a codec that encodes values with encoding/gob. Every value carries its type information, so gob is
+// only worth it for values the other codecs can't handle. It isn't order-preserving.
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+type gobCodec[T any] struct{}
+
+func (gobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// Marshaler is implemented by pointers to types that encode themselves, such as generated protobuf messages.
+type Marshaler[T any] interface {
+	*T
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
+// MarshalerCodec returns a codec for types that encode themselves through Marshal and Unmarshal methods on their
+// pointer. It isn't order-preserving.
+func MarshalerCodec[T any, PT Marshaler[T]]() Codec[T] {
+	return marshalerCodec[T, PT]{}
+}
+
+type marshalerCodec[T any, PT Marshaler[T]] struct{}
+
+func (marshalerCodec[T, PT]) Encode(v T) ([]byte, error) {
+	return PT(&v).Marshal()
+}
+
+func (marshalerCodec[T, PT]) Decode(b []byte) (T, error) {
+	var v T
+	err := PT(&v).Unmarshal(b)
+	return v, err
+}
+
+// TypedCollection wraps a collection to put and read keys and values of Go types, converting them with codecs.
+type TypedCollection[K, V any] struct {
+	collection *Collection
+	keys       Codec[K]
+	values     Codec[V]
+}
+
+// NewTypedCollection returns a typed view of the collection. The key codec must be order-preserving for cursors to
+// return the keys in order.
+func NewTypedCollection[K, V any](collection *Collection, keys Codec[K], values Codec[V]) *TypedCollection[K, V] {
+	return &TypedCollection[K, V]{
+		collection: collection,
+		keys:       keys,
+		values:     values,
+	}
+}
+
+// Collection returns the underlying collection.
+func (c *TypedCollection[K, V]) Collection() *Collection {
+	return c.collection
+}
+
+func (c *TypedCollection[K, V]) Put(key K, value V) error {
+	k, err := c.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	v, err := c.values.Encode(value)
+	if err != nil {
+		return err
+	}
+	return c.collection.Put(k, v)
+}
+
+// Get returns the value of the key, and false if the key doesn't exist.
+func (c *TypedCollection[K, V]) Get(key K) (V, bool, error) {
+	var value V
+	k, err := c.keys.Encode(key)
+	if err != nil {
+		return value, false, err
+	}
+
+	item, err := c.collection.Find(k)
+	if err != nil || item == nil {
+		return value, false, err
+	}
+	value, err = c.values.Decode(item.value)
+	if err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+func (c *TypedCollection[K, V]) Remove(key K) error {
+	k, err := c.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	return c.collection.Remove(k)
+}
+
+// TypedCursor iterates over a typed collection in key order, the same as Cursor.
+type TypedCursor[K, V any] struct {
+	cursor     *Cursor
+	collection *TypedCollection[K, V]
+}
+
+func (c *TypedCollection[K, V]) Cursor() *TypedCursor[K, V] {
+	return &TypedCursor[K, V]{
+		cursor:     c.collection.Cursor(),
+		collection: c,
+	}
+}
+
+// First moves the cursor to the first item. ok is false if the collection is empty.
+func (cur *TypedCursor[K, V]) First() (key K, value V, ok bool, err error) {
+	return cur.decode(cur.cursor.First())
+}
+
+// Seek moves the cursor to the first item whose key is equal to or bigger than the given key. ok is false if there's
+// no such item.
+func (cur *TypedCursor[K, V]) Seek(seek K) (key K, value V, ok bool, err error) {
+	k, err := cur.collection.keys.Encode(seek)
+	if err != nil {
+		return key, value, false, err
+	}
+	return cur.decode(cur.cursor.Seek(k))
+}
+
+// Next moves the cursor to the next item. ok is false once the cursor moved past the last item.
+func (cur *TypedCursor[K, V]) Next() (key K, value V, ok bool, err error) {
+	return cur.decode(cur.cursor.Next())
+}
+
+func (cur *TypedCursor[K, V]) decode(k []byte, v []byte, err error) (key K, value V, ok bool, _ error) {
+	if err != nil || k == nil {
+		return key, value, false, err
+	}
+	key, err = cur.collection.keys.Decode(k)
+	if err != nil {
+		return key, value, false, err
+	}
+	value, err = cur.collection.values.Decode(v)
+	if err != nil {
+		return key, value, false, err
+	}
+	return key, value, true, nil
+}
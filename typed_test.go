@@ -0,0 +1,190 @@
+package LibraDB
+
+import (
+	"bytes"
+	"errors"
+	"github.com/amit-davidson/LibraDB/tuple"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+// requireOrderPreserving checks the values, given in ascending order, are encoded in the same order and decoded back.
+func requireOrderPreserving[T any](t *testing.T, codec Codec[T], values ...T) {
+	var previous []byte
+	for i, value := range values {
+		b, err := codec.Encode(value)
+		require.NoError(t, err)
+		if i > 0 {
+			require.Equal(t, -1, bytes.Compare(previous, b), "%v should come after %v", value, values[i-1])
+		}
+		previous = b
+
+		decoded, err := codec.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, value, decoded)
+	}
+}
+
+func TestCodecs_OrderPreserving(t *testing.T) {
+	requireOrderPreserving(t, Uint64Codec, 0, 1, 255, 256, math.MaxUint64)
+	requireOrderPreserving(t, Int64Codec, math.MinInt64, -256, -1, 0, 1, 256, math.MaxInt64)
+	requireOrderPreserving(t, Float64Codec, math.Inf(-1), -1e10, -1.5, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 1.5, 1e10, math.Inf(1))
+	requireOrderPreserving(t, StringCodec, "", "a", "ab", "b")
+	requireOrderPreserving(t, TimeCodec,
+		time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999, time.UTC),
+		time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2022, 3, 1, 12, 0, 0, 1, time.UTC),
+		time.Date(2022, 3, 1, 12, 0, 1, 0, time.UTC),
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+	)
+	requireOrderPreserving(t, PairCodec(StringCodec, Int64Codec),
+		Pair[string, int64]{"", 5},
+		Pair[string, int64]{"a", -1},
+		Pair[string, int64]{"a", 0},
+		Pair[string, int64]{"a\x00", -5},
+		Pair[string, int64]{"a\x00b", -5},
+		Pair[string, int64]{"a\x01", -5},
+		Pair[string, int64]{"ab", -5},
+	)
+	requireOrderPreserving(t, PairCodec(Uint64Codec, PairCodec(StringCodec, StringCodec)),
+		Pair[uint64, Pair[string, string]]{1, Pair[string, string]{"b", "z"}},
+		Pair[uint64, Pair[string, string]]{2, Pair[string, string]{"a", "z"}},
+		Pair[uint64, Pair[string, string]]{2, Pair[string, string]{"b", "a"}},
+	)
+	requireOrderPreserving(t, TupleCodec,
+		tuple.Tuple{"a", int64(-1)},
+		tuple.Tuple{"a", int64(0)},
+		tuple.Tuple{"a", int64(0), "x"},
+		tuple.Tuple{"ab", int64(-5)},
+	)
+}
+
+func TestPairCodec_PacksTuples(t *testing.T) {
+	b, err := PairCodec(StringCodec, Int64Codec).Encode(Pair[string, int64]{"a\x00b", 5})
+	require.NoError(t, err)
+	second, err := Int64Codec.Encode(5)
+	require.NoError(t, err)
+	assert.Equal(t, tuple.Tuple{[]byte("a\x00b"), second}.MustPack(), b)
+
+	// The keys of a pair can be scanned with the range of its first component
+	start, end, err := tuple.Tuple{[]byte("a\x00b")}.Range()
+	require.NoError(t, err)
+	assert.Equal(t, -1, bytes.Compare(start, b))
+	assert.Equal(t, -1, bytes.Compare(b, end))
+}
+
+func TestCodecs_InvalidEncoding(t *testing.T) {
+	_, err := Uint64Codec.Decode([]byte{1, 2})
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+	_, err = TimeCodec.Decode(make([]byte, 8))
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+	_, err = PairCodec(StringCodec, StringCodec).Decode([]byte("no terminator"))
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+	_, err = PairCodec(StringCodec, StringCodec).Decode([]byte{'a', 0, 2})
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+	_, err = PairCodec(StringCodec, StringCodec).Decode(tuple.Tuple{"a", "b"}.MustPack())
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+	_, err = TupleCodec.Decode([]byte{0xfe})
+	assert.ErrorIs(t, err, ErrInvalidEncoding)
+}
+
+type testUser struct {
+	Name string
+	Age  int
+}
+
+// testMessage encodes itself like a generated protobuf message.
+type testMessage struct {
+	value string
+}
+
+func (m *testMessage) Marshal() ([]byte, error) {
+	return []byte(m.value), nil
+}
+
+func (m *testMessage) Unmarshal(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("empty message")
+	}
+	m.value = string(b)
+	return nil
+}
+
+func TestCodecs_Values(t *testing.T) {
+	user := testUser{"alice", 30}
+
+	b, err := JSONCodec[testUser]().Encode(user)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"alice","Age":30}`, string(b))
+	decoded, err := JSONCodec[testUser]().Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, user, decoded)
+
+	b, err = GobCodec[testUser]().Encode(user)
+	require.NoError(t, err)
+	decoded, err = GobCodec[testUser]().Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, user, decoded)
+
+	codec := MarshalerCodec[testMessage]()
+	b, err = codec.Encode(testMessage{"hello"})
+	require.NoError(t, err)
+	message, err := codec.Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, testMessage{"hello"}, message)
+	_, err = codec.Decode(nil)
+	assert.Error(t, err)
+}
+
+func TestTypedCollection(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	users := NewTypedCollection(collection, Int64Codec, JSONCodec[testUser]())
+	assert.Equal(t, collection, users.Collection())
+
+	for _, id := range []int64{10, -3, 7, 0} {
+		require.NoError(t, users.Put(id, testUser{"user", int(id)}))
+	}
+	require.NoError(t, users.Remove(7))
+	require.NoError(t, tx.Commit())
+
+	tx = db.ReadTx()
+	defer tx.Rollback()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	users = NewTypedCollection(collection, Int64Codec, JSONCodec[testUser]())
+
+	user, ok, err := users.Get(10)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testUser{"user", 10}, user)
+	_, ok, err = users.Get(7)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	var ids []int64
+	cursor := users.Cursor()
+	id, user, ok, err := cursor.First()
+	for ; ok && err == nil; id, user, ok, err = cursor.Next() {
+		assert.Equal(t, int(id), user.Age)
+		ids = append(ids, id)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, []int64{-3, 0, 10}, ids)
+
+	id, _, ok, err = cursor.Seek(-1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), id)
+	_, _, ok, err = cursor.Seek(11)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,187 @@
+package LibraDB
+
+import (
+	"bytes"
+	"sync"
+)
+
+type ChangeType int
+
+const (
+	ChangePut ChangeType = iota
+	ChangeDelete
+)
+
+// Change describes a key that was put or deleted by a committed transaction.
+type Change struct {
+	Type       ChangeType
+	Collection []byte
+	Key        []byte
+	// OldValue is nil if the key didn't exist before the change.
+	OldValue []byte
+	// NewValue is nil for deletes.
+	NewValue []byte
+	// TxID is the id of the transaction that committed the change. Changes of the same transaction share it.
+	TxID uint64
+}
+
+// Watcher receives the changes committed to the keys of a collection that start with a prefix. Changes are sent to
+// Events in commit order, and within a transaction in the order they were made. They are queued until they are
+// received, so a slow reader never blocks commits.
+type Watcher struct {
+	Events <-chan *Change
+
+	collection []byte
+	prefix     []byte
+
+	events chan *Change
+	mu     sync.Mutex
+	queue  []*Change
+	// notify wakes the goroutine sending the queue when changes are added to it.
+	notify    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	db *DB
+}
+
+// Watch returns a watcher of the changes committed to the keys of the collection that start with the prefix. Every
+// key is watched if the prefix is empty. Deleting the collection itself isn't reported. The watcher must be closed once
+// it's no longer needed.
+func (db *DB) Watch(collection []byte, prefix []byte) *Watcher {
+	events := make(chan *Change)
+	w := &Watcher{
+		Events:     events,
+		collection: append([]byte{}, collection...),
+		prefix:     append([]byte{}, prefix...),
+		events:     events,
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		db:         db,
+	}
+
+	db.watchMu.Lock()
+	db.watchers[w] = struct{}{}
+	db.watchMu.Unlock()
+
+	go w.run()
+	return w
+}
+
+// Close stops the watcher and closes Events. Changes that weren't received yet are dropped.
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		w.db.watchMu.Lock()
+		delete(w.db.watchers, w)
+		w.db.watchMu.Unlock()
+
+		close(w.done)
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	for {
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+
+		for _, change := range queue {
+			select {
+			case w.events <- change:
+			case <-w.done:
+				return
+			}
+		}
+
+		select {
+		case <-w.notify:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) matches(change *Change) bool {
+	return bytes.Equal(w.collection, change.Collection) && bytes.HasPrefix(change.Key, w.prefix)
+}
+
+func (w *Watcher) enqueue(changes []*Change) {
+	w.mu.Lock()
+	w.queue = append(w.queue, changes...)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (db *DB) isWatched() bool {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	return len(db.watchers) > 0
+}
+
+// publish queues the changes of a committed transaction to the watchers. It's called while the write lock is still
+// held, so transactions are published in commit order.
+func (db *DB) publish(changes []*Change, txid uint64) {
+	if len(changes) == 0 {
+		return
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	for _, change := range changes {
+		change.TxID = txid
+	}
+	for w := range db.watchers {
+		var matched []*Change
+		for _, change := range changes {
+			if w.matches(change) {
+				matched = append(matched, change)
+			}
+		}
+		if len(matched) > 0 {
+			w.enqueue(matched)
+		}
+	}
+}
+
+// closeWatchers closes every watcher of the database.
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	watchers := make([]*Watcher, 0, len(db.watchers))
+	for w := range db.watchers {
+		watchers = append(watchers, w)
+	}
+	db.watchMu.Unlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+}
+
+// recordChange keeps a change made to a collection so it's published if the transaction commits. Changes are recorded
+// only while the database has watchers, and not for hidden collections. The key and values are copied, since the
+// caller may reuse them.
+func (tx *tx) recordChange(collection *Collection, changeType ChangeType, key []byte, oldValue []byte, newValue []byte) {
+	if collection.isRoot || isReservedKey(collection.name) || !tx.db.isWatched() {
+		return
+	}
+
+	change := &Change{
+		Type:       changeType,
+		Collection: append([]byte{}, collection.name...),
+		Key:        append([]byte{}, key...),
+	}
+	if oldValue != nil {
+		change.OldValue = append([]byte{}, oldValue...)
+	}
+	if newValue != nil {
+		change.NewValue = append([]byte{}, newValue...)
+	}
+	tx.changes = append(tx.changes, change)
+}
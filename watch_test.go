@@ -0,0 +1,171 @@
+package LibraDB
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func receiveChange(t *testing.T, w *Watcher) *Change {
+	select {
+	case change := <-w.Events:
+		return change
+	case <-time.After(time.Second):
+		require.FailNow(t, "no change received")
+		return nil
+	}
+}
+
+func requireNoChange(t *testing.T, w *Watcher) {
+	select {
+	case change := <-w.Events:
+		require.FailNow(t, "unexpected change", "%+v", change)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	w := db.Watch(testCollectionName, []byte("user"))
+	defer w.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	other, err := tx.CreateCollection([]byte("other"))
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("user1"), []byte("alice")))
+	require.NoError(t, collection.Put([]byte("group1"), []byte("admins")))
+	require.NoError(t, other.Put([]byte("user1"), []byte("bob")))
+	require.NoError(t, tx.Commit())
+
+	change := receiveChange(t, w)
+	assert.Equal(t, &Change{Type: ChangePut, Collection: testCollectionName, Key: []byte("user1"), NewValue: []byte("alice"), TxID: change.TxID}, change)
+	requireNoChange(t, w)
+	firstTxID := change.TxID
+
+	// Rolled back changes aren't published
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("user2"), []byte("carol")))
+	tx.Rollback()
+	requireNoChange(t, w)
+
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("user1"), []byte("dave")))
+	require.NoError(t, collection.Remove([]byte("user1")))
+	require.NoError(t, collection.Remove([]byte("user3")))
+	require.NoError(t, tx.Commit())
+
+	change = receiveChange(t, w)
+	assert.Equal(t, &Change{Type: ChangePut, Collection: testCollectionName, Key: []byte("user1"), OldValue: []byte("alice"), NewValue: []byte("dave"), TxID: firstTxID + 1}, change)
+	change = receiveChange(t, w)
+	assert.Equal(t, &Change{Type: ChangeDelete, Collection: testCollectionName, Key: []byte("user1"), OldValue: []byte("dave"), TxID: firstTxID + 1}, change)
+	requireNoChange(t, w)
+}
+
+func TestDB_WatchSkipsExpiredDeletes(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+	advance := setTestClock(db)
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.PutWithTTL([]byte("user1"), []byte("alice"), time.Second))
+	require.NoError(t, collection.Put([]byte("user2"), []byte("bob")))
+	require.NoError(t, collection.PutWithTTL([]byte("user3"), []byte("carol"), time.Second))
+	require.NoError(t, collection.PutWithTTL([]byte("user4"), []byte("dave"), time.Hour))
+	require.NoError(t, tx.Commit())
+	advance(2 * time.Second)
+
+	w := db.Watch(testCollectionName, []byte("user"))
+	defer w.Close()
+
+	// Expired keys are absent, so removing them isn't reported
+	tx = db.WriteTx()
+	collection, err = tx.GetCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.DeleteRange([]byte("user1"), []byte("user3")))
+	require.NoError(t, collection.Truncate())
+	require.NoError(t, tx.Commit())
+
+	change := receiveChange(t, w)
+	assert.Equal(t, &Change{Type: ChangeDelete, Collection: testCollectionName, Key: []byte("user2"), OldValue: []byte("bob"), TxID: change.TxID}, change)
+	change = receiveChange(t, w)
+	assert.Equal(t, &Change{Type: ChangeDelete, Collection: testCollectionName, Key: []byte("user4"), OldValue: []byte("dave"), TxID: change.TxID}, change)
+	requireNoChange(t, w)
+}
+
+func TestDB_WatchDoesNotBlockCommits(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	w := db.Watch(testCollectionName, nil)
+	defer w.Close()
+
+	tx := db.WriteTx()
+	_, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// Nothing is received until all the transactions are committed
+	for i := 0; i < 50; i++ {
+		tx = db.WriteTx()
+		collection, err := tx.GetCollection(testCollectionName)
+		require.NoError(t, err)
+		require.NoError(t, collection.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")))
+		require.NoError(t, tx.Commit())
+	}
+
+	for i := 0; i < 50; i++ {
+		change := receiveChange(t, w)
+		assert.Equal(t, []byte(fmt.Sprintf("key%02d", i)), change.Key)
+	}
+}
+
+func TestDB_WatchRollbackToSavepoint(t *testing.T) {
+	db, cleanFunc := createTestDB(t)
+	defer cleanFunc()
+
+	w := db.Watch(testCollectionName, nil)
+	defer w.Close()
+
+	tx := db.WriteTx()
+	collection, err := tx.CreateCollection(testCollectionName)
+	require.NoError(t, err)
+	require.NoError(t, collection.Put([]byte("key1"), []byte("value")))
+	sp := tx.Savepoint()
+	require.NoError(t, collection.Put([]byte("key2"), []byte("value")))
+	require.NoError(t, tx.RollbackTo(sp))
+	require.NoError(t, tx.Commit())
+
+	change := receiveChange(t, w)
+	assert.Equal(t, []byte("key1"), change.Key)
+	requireNoChange(t, w)
+}
+
+func TestDB_WatchClose(t *testing.T) {
+	db, err := Open(MemoryPath, &Options{MinFillPercent: testMinPercentage, MaxFillPercent: testMaxPercentage})
+	require.NoError(t, err)
+
+	w := db.Watch(testCollectionName, nil)
+	w.Close()
+	_, ok := <-w.Events
+	assert.False(t, ok)
+	// Closing twice does nothing
+	w.Close()
+
+	// Closing the database closes its watchers
+	w = db.Watch(testCollectionName, nil)
+	require.NoError(t, db.Close())
+	_, ok = <-w.Events
+	assert.False(t, ok)
+}